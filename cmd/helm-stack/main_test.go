@@ -19,6 +19,7 @@ package main_test
 import (
 	"io/ioutil"
 	"os"
+	"regexp"
 	"strings"
 	"testing"
 
@@ -56,5 +57,10 @@ func TestSuite(t *testing.T) {
 	expected, err := ioutil.ReadFile("./testdata/expected-manifests.yaml")
 	assert.NoError(t, err)
 
+	// expected manifests are the output of `helm template`, namespace is inserted
+	// after the name of every resource
+	expected = regexp.MustCompile(`(?m)^(metadata:\n  name: .*\n)`).
+		ReplaceAll(expected, []byte("${1}  namespace: testing\n"))
+
 	assert.EqualValues(t, expected, actual)
 }
@@ -4,17 +4,33 @@ go 1.15
 
 require (
 	arhat.dev/pkg v0.5.4-0.20201208233302-107b8822e93b
+	github.com/Masterminds/semver/v3 v3.1.0
+	github.com/Masterminds/sprig/v3 v3.1.0
+	github.com/evanphx/json-patch v4.9.0+incompatible
 	github.com/rogpeppe/go-internal v1.6.2
 	github.com/spf13/cobra v1.1.1
 	github.com/stretchr/testify v1.6.1
+	github.com/xeipuuv/gojsonschema v1.2.0
 	go.uber.org/multierr v1.6.0
+	gopkg.in/yaml.v3 v3.0.0-20200615113413-eeeca48fe776
+	helm.sh/helm/v3 v3.4.2
 	k8s.io/apimachinery v0.19.4
+	k8s.io/cli-runtime v0.19.4
+	k8s.io/client-go v0.19.4
 	k8s.io/kubectl v0.19.4
+	sigs.k8s.io/kustomize v2.0.3+incompatible
 	sigs.k8s.io/yaml v1.2.0
 )
 
 replace github.com/creack/pty => github.com/jeffreystoke/pty v1.1.12-0.20201126201855-c1c1e24408f9
 
+// keep in sync with helm.sh/helm/v3
+replace (
+	github.com/Azure/go-autorest => github.com/Azure/go-autorest v13.3.2+incompatible
+	github.com/docker/distribution => github.com/docker/distribution v0.0.0-20191216044856-a8371794149d
+	github.com/opencontainers/runc => github.com/opencontainers/runc v0.1.1
+)
+
 replace (
 	k8s.io/api => github.com/kubernetes/api v0.19.4
 	k8s.io/apiextensions-apiserver => github.com/kubernetes/apiextensions-apiserver v0.19.4
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"arhat.dev/helm-stack/pkg/conf"
+	"arhat.dev/helm-stack/pkg/constant"
+)
+
+func NewCapabilitiesCommand(appCtx *context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:           "capabilities",
+		Short:         "manage kubernetes capabilities used to render charts",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+	}
+
+	cmd.AddCommand(
+		newCapabilitiesCaptureCommand(appCtx),
+	)
+
+	return cmd
+}
+
+func newCapabilitiesCaptureCommand(appCtx *context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:           "capture <environment name 1> ... <environment name N>",
+		Short:         "capture kubernetes version and api versions from the cluster into capabilities file",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		Args:          cobra.MinimumNArgs(1),
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config := (*appCtx).Value(constant.ContextKeyConfig).(*conf.ResolvedConfig)
+			return runCapabilitiesCapture(*appCtx, config, args)
+		},
+	}
+
+	return cmd
+}
+
+func runCapabilitiesCapture(ctx context.Context, config *conf.ResolvedConfig, names []string) error {
+	toCapture, err := GetEnvironmentsToRun(names, config)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range toCapture {
+		fmt.Println("--- Capturing Capabilities:", e.Name)
+
+		err = e.CaptureCapabilities(ctx, config.App.EnvironmentsDir)
+		if err != nil {
+			return fmt.Errorf("failed to capture capabilities of environment %q: %w", e.Name, err)
+		}
+	}
+
+	return nil
+}
@@ -150,23 +150,39 @@ func collectEnvironmentFilesToRemove(config *conf.ResolvedConfig, e *conf.Enviro
 		valuesFileWanted = make(map[string]struct{})
 	)
 
-	for _, d := range e.Deployments {
+	manifestsWanted := make(map[string]struct{})
+	for i, d := range e.Deployments {
+		manifestsWanted[e.ManifestsPath(config.App.EnvironmentsDir, &e.Deployments[i])] = struct{}{}
+		manifestsWanted[e.CRDsPath(config.App.EnvironmentsDir, &e.Deployments[i])] = struct{}{}
+		manifestsWanted[e.HooksPath(config.App.EnvironmentsDir, &e.Deployments[i])] = struct{}{}
+
 		chart := config.Charts[d.Chart]
 		if chart == nil {
 			return nil, fmt.Errorf("chart %q does not exists", d.Chart)
 		}
 
-		subChartNames, err := chart.SubChartNames(config.App.ChartsDir, config.App.LocalChartsDir)
+		subCharts, err := e.EnabledSubCharts(
+			config.App.ChartsDir, config.App.LocalChartsDir, config.App.EnvironmentsDir, d, chart,
+		)
 		if err != nil {
-			return nil, fmt.Errorf("failed to check sub charts")
+			return nil, fmt.Errorf("failed to check sub charts: %w", err)
 		}
 
-		for _, subChartName := range append([]string{""}, subChartNames...) {
-			f := filepath.Join(e.ValuesDir(config.App.EnvironmentsDir), d.Filename(subChartName))
-			valuesFileWanted[f] = struct{}{}
+		for _, sub := range subCharts {
+			valuesDir := e.ValuesDir(config.App.EnvironmentsDir)
+			valuesFileWanted[filepath.Join(valuesDir, d.Filename(sub.Name))] = struct{}{}
+			valuesFileWanted[filepath.Join(valuesDir, d.ValuesTemplateFilename(sub.Name))] = struct{}{}
 		}
 	}
 
+	if f := e.GlobalValuesFilePath(config.App.EnvironmentsDir); f != "" {
+		valuesFileWanted[f] = struct{}{}
+	}
+	valuesFileWanted[e.CapabilitiesFilePath(config.App.EnvironmentsDir)] = struct{}{}
+	if f := e.ImageDigestsFilePath(config.App.EnvironmentsDir); f != "" {
+		valuesFileWanted[f] = struct{}{}
+	}
+
 	valuesDir := e.ValuesDir(config.App.EnvironmentsDir)
 
 	filesInValuesDir, err := ioutil.ReadDir(valuesDir)
@@ -178,7 +194,27 @@ func collectEnvironmentFilesToRemove(config *conf.ResolvedConfig, e *conf.Enviro
 		path := filepath.Join(valuesDir, f.Name())
 
 		if f.IsDir() {
-			// do not remove directories
+			if strings.HasPrefix(f.Name(), conf.ManifestsStagingDirPrefix) ||
+				strings.HasPrefix(f.Name(), conf.ManifestsBackupDirPrefix) {
+				// left by interrupted gen
+				filesToRemove = append(filesToRemove, path)
+			}
+
+			if f.Name() == conf.UpstreamValuesDir {
+				// upstream values of values files removed
+				snapshots, err := ioutil.ReadDir(path)
+				if err != nil {
+					return nil, fmt.Errorf("failed to inspect upstream values dir %q: %w", path, err)
+				}
+
+				for _, s := range snapshots {
+					if _, ok := valuesFileWanted[filepath.Join(valuesDir, s.Name())]; !ok {
+						filesToRemove = append(filesToRemove, filepath.Join(path, s.Name()))
+					}
+				}
+			}
+
+			// do not remove other directories
 			continue
 		}
 
@@ -187,6 +223,20 @@ func collectEnvironmentFilesToRemove(config *conf.ResolvedConfig, e *conf.Enviro
 		}
 	}
 
+	// manifests of deployments removed or generated in another layout
+	manifestsDir := e.ManifestsDir(config.App.EnvironmentsDir)
+	manifests, err := ioutil.ReadDir(manifestsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to inspect environment manifests dir %q: %w", manifestsDir, err)
+	}
+
+	for _, f := range manifests {
+		path := filepath.Join(manifestsDir, f.Name())
+		if _, ok := manifestsWanted[path]; !ok {
+			filesToRemove = append(filesToRemove, path)
+		}
+	}
+
 	return filesToRemove, nil
 }
 
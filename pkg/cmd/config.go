@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+
+	"arhat.dev/helm-stack/pkg/conf"
+	"arhat.dev/helm-stack/pkg/constant"
+)
+
+func NewConfigCommand(appCtx *context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:           "config",
+		Short:         "inspect resolved config",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+	}
+
+	cmd.AddCommand(
+		newConfigViewCommand(appCtx),
+	)
+
+	return cmd
+}
+
+func newConfigViewCommand(appCtx *context.Context) *cobra.Command {
+	var (
+		output string
+	)
+
+	cmd := &cobra.Command{
+		Use:           "view",
+		Short:         "print config merged from all config files",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		Args:          cobra.NoArgs,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config := (*appCtx).Value(constant.ContextKeyConfig).(*conf.ResolvedConfig)
+			return runConfigView(config, output)
+		},
+	}
+
+	fs := cmd.Flags()
+	fs.StringVarP(&output, "output", "o", "yaml", "output format, one of [yaml, json]")
+
+	return cmd
+}
+
+func runConfigView(config *conf.ResolvedConfig, output string) error {
+	if output != "yaml" && output != "json" {
+		return fmt.Errorf("unsupported output format %q", output)
+	}
+
+	c := conf.Config{App: *config.App}
+	for _, name := range sortedNames(config.Repos) {
+		c.Repos = append(c.Repos, *config.Repos[name])
+	}
+
+	for _, name := range sortedNames(config.Charts) {
+		c.Charts = append(c.Charts, *config.Charts[name])
+	}
+
+	for _, name := range sortedNames(config.Environments) {
+		c.Environments = append(c.Environments, *config.Environments[name])
+	}
+
+	if output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(c)
+	}
+
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+// sortedNames returns sorted keys of map m of resolved config
+func sortedNames(m interface{}) []string {
+	var ret []string
+	switch t := m.(type) {
+	case map[string]*conf.RepoSpec:
+		for name := range t {
+			ret = append(ret, name)
+		}
+	case map[string]*conf.ChartSpec:
+		for name := range t {
+			ret = append(ret, name)
+		}
+	case map[string]*conf.Environment:
+		for name := range t {
+			ret = append(ret, name)
+		}
+	}
+
+	sort.Strings(ret)
+	return ret
+}
@@ -12,7 +12,8 @@ import (
 
 func NewEnsureCommand(appCtx *context.Context) *cobra.Command {
 	var (
-		forcePull bool
+		forcePull     bool
+		refreshValues bool
 	)
 
 	cmd := &cobra.Command{
@@ -25,17 +26,19 @@ func NewEnsureCommand(appCtx *context.Context) *cobra.Command {
 
 		RunE: func(cmd *cobra.Command, args []string) error {
 			config := (*appCtx).Value(constant.ContextKeyConfig).(*conf.ResolvedConfig)
-			return runEnsure(*appCtx, config, forcePull)
+			return runEnsure(*appCtx, config, forcePull, refreshValues)
 		},
 	}
 
 	fs := cmd.Flags()
 	fs.BoolVar(&forcePull, "force-pull", false, "pull chart even though already exists")
+	fs.BoolVar(&refreshValues, "refresh-values", false,
+		"add values keys newly introduced by charts to existing values files")
 
 	return cmd
 }
 
-func runEnsure(ctx context.Context, config *conf.ResolvedConfig, forcePull bool) error {
+func runEnsure(ctx context.Context, config *conf.ResolvedConfig, forcePull, refreshValues bool) error {
 	for _, c := range config.Charts {
 		fmt.Println("--- Ensuring Chart:", c.Name)
 
@@ -50,6 +53,7 @@ func runEnsure(ctx context.Context, config *conf.ResolvedConfig, forcePull bool)
 
 		if err := e.Ensure(
 			ctx,
+			refreshValues,
 			config.App.ChartsDir,
 			config.App.LocalChartsDir,
 			config.App.EnvironmentsDir,
@@ -3,15 +3,27 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"os"
+	"sync"
 
 	"arhat.dev/helm-stack/pkg/conf"
 
 	"github.com/spf13/cobra"
+	"go.uber.org/multierr"
 
 	"arhat.dev/helm-stack/pkg/constant"
 )
 
 func NewGenCommand(appCtx *context.Context) *cobra.Command {
+	var (
+		opts      conf.GenOptions
+		noSecrets bool
+		renderer  string
+		jobs      int
+		cache     bool
+		cacheDir  string
+	)
+
 	cmd := &cobra.Command{
 		Use:           "gen <environment name 1> ... <environment name N>",
 		Short:         "generate manifests according to your custom values",
@@ -21,32 +33,100 @@ func NewGenCommand(appCtx *context.Context) *cobra.Command {
 
 		RunE: func(cmd *cobra.Command, args []string) error {
 			config := (*appCtx).Value(constant.ContextKeyConfig).(*conf.ResolvedConfig)
-			return runGen(*appCtx, config, args)
+			opts.RefResolver = conf.NewValuesRefResolver(noSecrets)
+
+			if renderer == "" {
+				renderer = config.App.Renderer
+			}
+
+			var err error
+			opts.Renderer, err = conf.NewRenderer(renderer)
+			if err != nil {
+				return err
+			}
+
+			opts.Limiter = conf.NewJobLimiter(jobs)
+
+			if cache && cacheDir == "" {
+				cacheDir, err = conf.DefaultRenderCacheDir()
+				if err != nil {
+					// not fatal, render all deployments
+					_, _ = fmt.Fprintf(os.Stderr, "Not caching manifests: %v\n", err)
+				}
+			}
+
+			if cache && cacheDir != "" {
+				opts.Cache = conf.NewRenderCache(cacheDir)
+			}
+
+			return runGen(*appCtx, config, opts, args)
 		},
 	}
 
+	fs := cmd.Flags()
+	fs.BoolVar(&opts.Strict, "strict", false, "fail when values contain keys not defined in charts")
+	fs.BoolVar(&noSecrets, "no-secrets", false, "replace values refs (ref+<scheme>://) with placeholders")
+	fs.IntVarP(&jobs, "jobs", "j", 1, "number of deployments rendered concurrently across all environments")
+	fs.BoolVar(&cache, "cache", true,
+		"cache rendered manifests and reuse them for unchanged deployments without values refs, "+
+			"disable with --cache=false")
+	fs.BoolVar(&opts.Force, "force", false, "render all deployments without reusing cached manifests")
+	fs.StringVar(&cacheDir, "cache-dir", "",
+		"dir to cache rendered manifests, defaults to <user cache dir>/helm-stack/manifests")
+	fs.StringVar(&renderer, "renderer", "", "chart renderer, one of [sdk, exec], defaults to app.renderer in config")
+
 	return cmd
 }
 
-func runGen(ctx context.Context, config *conf.ResolvedConfig, names []string) error {
+func runGen(ctx context.Context, config *conf.ResolvedConfig, opts conf.GenOptions, names []string) error {
 	toGen, err := GetEnvironmentsToRun(names, config)
 	if err != nil {
 		return err
 	}
 
+	if opts.Stats == nil {
+		opts.Stats = new(conf.GenStats)
+	}
+
+	var (
+		wg     = new(sync.WaitGroup)
+		mu     = new(sync.Mutex)
+		result error
+		seen   = make(map[string]struct{})
+	)
+
 	for _, e := range toGen {
+		if _, ok := seen[e.Name]; ok {
+			// environments are generated concurrently, do not write the same dir twice
+			continue
+		}
+		seen[e.Name] = struct{}{}
+
 		fmt.Println("--- Generating Manifests:", e.Name)
 
-		if err := e.Gen(
-			ctx,
-			config.App.ChartsDir,
-			config.App.LocalChartsDir,
-			config.App.EnvironmentsDir,
-			config.Charts,
-		); err != nil {
-			return fmt.Errorf("failed to generate manifests %q: %w", e.Name, err)
-		}
+		wg.Add(1)
+		go func(e *conf.Environment) {
+			defer wg.Done()
+
+			err := e.Gen(
+				ctx,
+				config.App.ChartsDir,
+				config.App.LocalChartsDir,
+				config.App.EnvironmentsDir,
+				config.Charts,
+				opts,
+			)
+			if err != nil {
+				mu.Lock()
+				result = multierr.Append(result, fmt.Errorf("failed to generate manifests %q: %w", e.Name, err))
+				mu.Unlock()
+			}
+		}(e)
 	}
 
-	return nil
+	wg.Wait()
+
+	fmt.Printf("--- Rendered %d deployment(s), reused %d from cache\n", opts.Stats.Rendered(), opts.Stats.Reused())
+
+	return result
 }
@@ -25,6 +25,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"reflect"
 	"syscall"
 
 	"github.com/spf13/cobra"
@@ -52,7 +53,7 @@ func NewHelmStackCmd() *cobra.Command {
 			}
 
 			ctx, exit := context.WithCancel(context.Background())
-			sigCh := make(chan os.Signal)
+			sigCh := make(chan os.Signal, 1)
 			signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
 			go func() {
 				i := 0
@@ -68,7 +69,7 @@ func NewHelmStackCmd() *cobra.Command {
 				}
 			}()
 
-			userDefinedConfigs := cmd.Parent().PersistentFlags().Lookup("config").Changed
+			userDefinedConfigs := cmd.Root().PersistentFlags().Lookup("config").Changed
 
 			for _, confFile := range configFiles {
 				err := readConfigAndResolve(confFile, config)
@@ -106,9 +107,19 @@ func NewHelmStackCmd() *cobra.Command {
 				if err := c.Validate(config.Repos); err != nil {
 					return fmt.Errorf("chart %q not valid: %w", c.Name, err)
 				}
+
+				if c.NamespaceInTemplate {
+					_, _ = fmt.Fprintf(os.Stderr,
+						"chart %q: namespaceInTemplate is deprecated and has no effect, "+
+							"namespace is set in generated manifests\n", c.Name)
+				}
 			}
 
 			for _, e := range config.Environments {
+				if e.ManifestsLayout == "" {
+					e.ManifestsLayout = config.App.ManifestsLayout
+				}
+
 				if err := e.Validate(config.Charts); err != nil {
 					return fmt.Errorf("environment %q not valid: %w", e.Name, err)
 				}
@@ -140,6 +151,10 @@ func NewHelmStackCmd() *cobra.Command {
 		NewGenCommand(&appCtx),
 		NewApplyCommand(&appCtx),
 		NewCleanCommand(&appCtx),
+		NewValuesCommand(&appCtx),
+		NewCapabilitiesCommand(&appCtx),
+		NewImagesCommand(&appCtx),
+		NewConfigCommand(&appCtx),
 	)
 
 	return cmd
@@ -199,6 +214,92 @@ func readConfigAndResolve(configFileOrDir string, rc *conf.ResolvedConfig) error
 					return fmt.Errorf("environment %q configured with multiple kubeContext", e.Name)
 				}
 
+				switch {
+				case existingEnv.GlobalValuesFile == "":
+					existingEnv.GlobalValuesFile = e.GlobalValuesFile
+				case e.GlobalValuesFile != "" && e.GlobalValuesFile != existingEnv.GlobalValuesFile:
+					return fmt.Errorf("environment %q configured with multiple globalValuesFile", e.Name)
+				}
+
+				switch {
+				case len(existingEnv.GlobalValues) == 0:
+					existingEnv.GlobalValues = e.GlobalValues
+				case len(e.GlobalValues) != 0:
+					return fmt.Errorf("environment %q configured with globalValues in multiple config files", e.Name)
+				}
+
+				switch {
+				case existingEnv.ManifestsLayout == "":
+					existingEnv.ManifestsLayout = e.ManifestsLayout
+				case e.ManifestsLayout != "" && e.ManifestsLayout != existingEnv.ManifestsLayout:
+					return fmt.Errorf("environment %q configured with multiple manifestsLayout", e.Name)
+				}
+
+				switch {
+				case existingEnv.KubeVersion == "":
+					existingEnv.KubeVersion = e.KubeVersion
+				case e.KubeVersion != "" && e.KubeVersion != existingEnv.KubeVersion:
+					return fmt.Errorf("environment %q configured with multiple kubeVersion", e.Name)
+				}
+
+				switch {
+				case existingEnv.CapabilitiesFile == "":
+					existingEnv.CapabilitiesFile = e.CapabilitiesFile
+				case e.CapabilitiesFile != "" && e.CapabilitiesFile != existingEnv.CapabilitiesFile:
+					return fmt.Errorf("environment %q configured with multiple capabilitiesFile", e.Name)
+				}
+
+				// duplicates are removed when resolving capabilities
+				existingEnv.APIVersions = append(existingEnv.APIVersions, e.APIVersions...)
+
+				switch {
+				case existingEnv.ImageDigestsFile == "":
+					existingEnv.ImageDigestsFile = e.ImageDigestsFile
+				case e.ImageDigestsFile != "" && e.ImageDigestsFile != existingEnv.ImageDigestsFile:
+					return fmt.Errorf("environment %q configured with multiple imageDigestsFile", e.Name)
+				}
+
+				// rules in later config files have lower priority
+				existingEnv.ImageRewrites = append(existingEnv.ImageRewrites, e.ImageRewrites...)
+
+				switch {
+				case !existingEnv.Normalize.Enabled():
+					existingEnv.Normalize = e.Normalize
+				case e.Normalize.Enabled() && !reflect.DeepEqual(e.Normalize, existingEnv.Normalize):
+					return fmt.Errorf("environment %q configured with multiple normalize", e.Name)
+				}
+
+				// merge environment labels
+				for k, v := range e.Labels {
+					if existingV, ok := existingEnv.Labels[k]; ok && existingV != v {
+						return fmt.Errorf("environment %q configured with conflicting label %q", e.Name, k)
+					}
+
+					if existingEnv.Labels == nil {
+						existingEnv.Labels = make(map[string]string)
+					}
+					existingEnv.Labels[k] = v
+				}
+
+				// merge environment common labels and annotations
+				existingEnv.CommonLabels, err = mergeCommonMetadata(
+					e.Name, "commonLabels", existingEnv.CommonLabels, e.CommonLabels,
+				)
+				if err != nil {
+					return err
+				}
+
+				existingEnv.CommonAnnotations, err = mergeCommonMetadata(
+					e.Name, "commonAnnotations", existingEnv.CommonAnnotations, e.CommonAnnotations,
+				)
+				if err != nil {
+					return err
+				}
+
+				existingEnv.BuiltinLabels = existingEnv.BuiltinLabels || e.BuiltinLabels
+				existingEnv.CommonMetadataInPodTemplates = existingEnv.CommonMetadataInPodTemplates ||
+					e.CommonMetadataInPodTemplates
+
 				// merge environment deployments
 				rc.Environments[e.Name].Deployments = append(
 					rc.Environments[e.Name].Deployments,
@@ -220,3 +321,18 @@ func readConfigAndResolve(configFileOrDir string, rc *conf.ResolvedConfig) error
 
 	return nil
 }
+
+func mergeCommonMetadata(envName, field string, existing, m map[string]string) (map[string]string, error) {
+	for k, v := range m {
+		if existingV, ok := existing[k]; ok && existingV != v {
+			return nil, fmt.Errorf("environment %q configured with conflicting %s %q", envName, field, k)
+		}
+
+		if existing == nil {
+			existing = make(map[string]string)
+		}
+		existing[k] = v
+	}
+
+	return existing, nil
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"arhat.dev/helm-stack/pkg/conf"
+	"arhat.dev/helm-stack/pkg/constant"
+)
+
+func NewImagesCommand(appCtx *context.Context) *cobra.Command {
+	var (
+		output string
+	)
+
+	cmd := &cobra.Command{
+		Use:           "images <environment name 1> ... <environment name N>",
+		Short:         "list container images in generated and custom manifests",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		Args:          cobra.MinimumNArgs(1),
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config := (*appCtx).Value(constant.ContextKeyConfig).(*conf.ResolvedConfig)
+			return runImages(*appCtx, config, output, args)
+		},
+	}
+
+	fs := cmd.Flags()
+	fs.StringVarP(&output, "output", "o", "text", "output format, one of [text, json]")
+
+	return cmd
+}
+
+type imageUsage struct {
+	Image string `json:"image"`
+	// Deployments using the image in `<environment>:<namespace>/<name>` format
+	Deployments []string `json:"deployments"`
+}
+
+func runImages(ctx context.Context, config *conf.ResolvedConfig, output string, names []string) error {
+	_ = ctx
+
+	if output != "text" && output != "json" {
+		return fmt.Errorf("unsupported output format %q", output)
+	}
+
+	toList, err := GetEnvironmentsToRun(names, config)
+	if err != nil {
+		return err
+	}
+
+	usage := make(map[string][]string)
+	for _, e := range toList {
+		images, err := e.Images(config.App.EnvironmentsDir)
+		if err != nil {
+			return fmt.Errorf("failed to list images of environment %q: %w", e.Name, err)
+		}
+
+		for image, deployments := range images {
+			for _, d := range deployments {
+				usage[image] = append(usage[image], e.Name+":"+d)
+			}
+		}
+	}
+
+	result := make([]imageUsage, 0, len(usage))
+	for image, deployments := range usage {
+		result = append(result, imageUsage{Image: image, Deployments: deployments})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Image < result[j].Image
+	})
+
+	if output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, u := range result {
+		_, _ = fmt.Fprintf(w, "%s\t%s\n", u.Image, strings.Join(u.Deployments, ","))
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"arhat.dev/helm-stack/pkg/conf"
+	"arhat.dev/helm-stack/pkg/constant"
+)
+
+func NewValuesCommand(appCtx *context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:           "values",
+		Short:         "inspect values of deployments",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+	}
+
+	cmd.AddCommand(
+		newValuesValidateCommand(appCtx),
+		newValuesLintCommand(appCtx),
+	)
+
+	return cmd
+}
+
+func newValuesValidateCommand(appCtx *context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:           "validate <environment name 1> ... <environment name N>",
+		Short:         "validate values against values schema of charts",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		Args:          cobra.MinimumNArgs(1),
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config := (*appCtx).Value(constant.ContextKeyConfig).(*conf.ResolvedConfig)
+			return runValuesValidate(*appCtx, config, args)
+		},
+	}
+
+	return cmd
+}
+
+func runValuesValidate(ctx context.Context, config *conf.ResolvedConfig, names []string) error {
+	_ = ctx
+	toValidate, err := GetEnvironmentsToRun(names, config)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range toValidate {
+		fmt.Println("--- Validating Values:", e.Name)
+
+		if err := e.ValidateValues(
+			config.App.ChartsDir,
+			config.App.LocalChartsDir,
+			config.App.EnvironmentsDir,
+			config.Charts,
+		); err != nil {
+			return fmt.Errorf("invalid values in environment %q: %w", e.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func newValuesLintCommand(appCtx *context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:           "lint <environment name 1> ... <environment name N>",
+		Short:         "check values files for keys not defined in charts",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		Args:          cobra.MinimumNArgs(1),
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config := (*appCtx).Value(constant.ContextKeyConfig).(*conf.ResolvedConfig)
+			return runValuesLint(*appCtx, config, args)
+		},
+	}
+
+	return cmd
+}
+
+func runValuesLint(ctx context.Context, config *conf.ResolvedConfig, names []string) error {
+	_ = ctx
+	toLint, err := GetEnvironmentsToRun(names, config)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range toLint {
+		fmt.Println("--- Linting Values:", e.Name)
+
+		if err := e.LintValues(
+			config.App.ChartsDir,
+			config.App.LocalChartsDir,
+			config.App.EnvironmentsDir,
+			config.Charts,
+		); err != nil {
+			return fmt.Errorf("unknown values in environment %q: %w", e.Name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package conf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"arhat.dev/pkg/exechelper"
+	"k8s.io/kubectl/pkg/cmd/version"
+	"sigs.k8s.io/yaml"
+)
+
+// DefaultCapabilitiesFile is the capabilities file in the environment values dir used when
+// capabilitiesFile is not set
+const DefaultCapabilitiesFile = "capabilities.yaml"
+
+// Capabilities of the kubernetes cluster used to render charts (`.Capabilities` in templates)
+type Capabilities struct {
+	KubeVersion string   `json:"kubeVersion" yaml:"kubeVersion"`
+	APIVersions []string `json:"apiVersions" yaml:"apiVersions"`
+}
+
+// CapabilitiesFilePath returns the path of the environment capabilities file
+func (e Environment) CapabilitiesFilePath(envDir string) string {
+	file := e.CapabilitiesFile
+	if file == "" {
+		file = DefaultCapabilitiesFile
+	}
+
+	if filepath.IsAbs(file) {
+		return file
+	}
+
+	return filepath.Join(e.ValuesDir(envDir), file)
+}
+
+// resolveCapabilities merges inline kubeVersion and apiVersions over the capabilities file,
+// the file is optional
+func (e Environment) resolveCapabilities(envDir string) (*Capabilities, error) {
+	ret := new(Capabilities)
+
+	file := e.CapabilitiesFilePath(envDir)
+	data, err := ioutil.ReadFile(file)
+	switch {
+	case err == nil:
+		err = yaml.Unmarshal(data, ret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse capabilities file %q: %w", file, err)
+		}
+	case os.IsNotExist(err):
+	default:
+		return nil, fmt.Errorf("failed to read capabilities file %q: %w", file, err)
+	}
+
+	if e.KubeVersion != "" {
+		ret.KubeVersion = e.KubeVersion
+	}
+
+	seen := make(map[string]struct{})
+	var apiVersions []string
+	for _, v := range append(ret.APIVersions, e.APIVersions...) {
+		if _, ok := seen[v]; ok || v == "" {
+			continue
+		}
+
+		seen[v] = struct{}{}
+		apiVersions = append(apiVersions, v)
+	}
+	sort.Strings(apiVersions)
+	ret.APIVersions = apiVersions
+
+	return ret, nil
+}
+
+// CaptureCapabilities queries kubernetes version and api versions of the cluster with kubectl,
+// and writes them to the capabilities file
+func (e Environment) CaptureCapabilities(ctx context.Context, envDir string) error {
+	kubectlCmd := []string{"kubectl"}
+	if e.KubeContext != "" {
+		kubectlCmd = append(kubectlCmd, "--context", e.KubeContext)
+	}
+
+	buf := new(bytes.Buffer)
+	err := execKubectlOutput(ctx, assembleCommandWithoutEmptyString(kubectlCmd, "version", "--output", "json"), buf)
+	if err != nil {
+		return fmt.Errorf("failed to get kubernetes version: %w", err)
+	}
+
+	ver := new(version.Version)
+	err = json.NewDecoder(buf).Decode(ver)
+	if err != nil {
+		return fmt.Errorf("failed to parse kubernetes version: %w", err)
+	}
+
+	if ver.ServerVersion == nil {
+		return fmt.Errorf("no server version of kubernetes")
+	}
+
+	buf.Reset()
+	err = execKubectlOutput(ctx, assembleCommandWithoutEmptyString(kubectlCmd, "api-versions"), buf)
+	if err != nil {
+		return fmt.Errorf("failed to get api versions: %w", err)
+	}
+
+	c := &Capabilities{
+		KubeVersion: ver.ServerVersion.GitVersion,
+	}
+	for _, v := range strings.Split(buf.String(), "\n") {
+		if v = strings.TrimSpace(v); v != "" {
+			c.APIVersions = append(c.APIVersions, v)
+		}
+	}
+	sort.Strings(c.APIVersions)
+
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to marshal capabilities: %w", err)
+	}
+
+	file := e.CapabilitiesFilePath(envDir)
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to ensure dir for capabilities file: %w", err)
+	}
+
+	return writeFileAtomic(file, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+func execKubectlOutput(ctx context.Context, cmd []string, out io.Writer) error {
+	fmt.Println("Executing:", strings.Join(cmd, " "))
+	proc, err := exechelper.Do(exechelper.Spec{
+		Context: ctx,
+		Command: cmd,
+		Stdout:  out,
+		Stderr:  os.Stderr,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to execute kubectl command: %w", err)
+	}
+
+	_, err = proc.Wait()
+	return err
+}
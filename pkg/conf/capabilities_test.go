@@ -0,0 +1,43 @@
+package conf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveCapabilities(t *testing.T) {
+	envDir, err := ioutil.TempDir("", "helm-stack-test-*")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = os.RemoveAll(envDir) }()
+
+	e := Environment{
+		Name:        "test",
+		KubeVersion: "v1.16.0",
+		APIVersions: []string{"example.com/v1", "v1"},
+	}
+
+	c, err := e.resolveCapabilities(envDir)
+	assert.NoError(t, err)
+	assert.Equal(t, &Capabilities{KubeVersion: "v1.16.0", APIVersions: []string{"example.com/v1", "v1"}}, c)
+
+	assert.NoError(t, os.MkdirAll(e.ValuesDir(envDir), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(e.ValuesDir(envDir), DefaultCapabilitiesFile), []byte(`
+kubeVersion: v1.16.15
+apiVersions:
+- v1
+- apps/v1
+`), 0644))
+
+	c, err = e.resolveCapabilities(envDir)
+	assert.NoError(t, err)
+	assert.Equal(t, &Capabilities{
+		KubeVersion: "v1.16.0",
+		APIVersions: []string{"apps/v1", "example.com/v1", "v1"},
+	}, c)
+}
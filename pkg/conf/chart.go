@@ -24,33 +24,12 @@ type ChartSpec struct {
 
 	// NamespaceInTemplate means there is already proper namespace information defined in its templates
 	// and apply with `kubectl --namespace` will fail (mostly for rbac resources)
+	//
+	// Deprecated: namespace is set in generated manifests for namespaced resources, and
+	// `kubectl --namespace` is no longer used
 	NamespaceInTemplate bool `json:"namespaceInTemplate" yaml:"namespaceInTemplate"`
 }
 
-func (c ChartSpec) SubChartNames(chartsDir, localChartsDir string) ([]string, error) {
-	var baseDir string
-	switch {
-	case c.ChartSource != nil && c.ChartSource.Local != nil:
-		baseDir = localChartsDir
-	default:
-		baseDir = chartsDir
-	}
-
-	files, err := ioutil.ReadDir(filepath.Join(c.Dir(baseDir, localChartsDir, ""), "charts"))
-	if err != nil && !errors.Is(err, os.ErrNotExist) {
-		return nil, fmt.Errorf("failed to check sub charts")
-	}
-
-	result := []string{""}
-	for _, f := range files {
-		if f.IsDir() {
-			result = append(result, f.Name())
-		}
-	}
-
-	return result, nil
-}
-
 func (c ChartSpec) Dir(chartsDir, localChartsDir string, subChartName string) string {
 	var baseDir string
 	switch {
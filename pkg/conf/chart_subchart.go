@@ -0,0 +1,381 @@
+package conf
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+
+	"arhat.dev/helm-stack/pkg/constant"
+)
+
+// SubChart is a chart in the `charts` dir of its parent chart
+type SubChart struct {
+	// Name of the sub chart used as values key in parent chart (alias if set),
+	// empty for the parent chart itself
+	Name string
+
+	// ChartName is the name defined in the Chart.yaml of the sub chart
+	ChartName string
+
+	// Condition and Tags defined in dependencies of parent chart
+	Condition string
+	Tags      []string
+
+	// Dir of the chart, empty if it's a packaged sub chart
+	Dir string
+
+	// Archive is the path to the packaged sub chart (`charts/<name>-<version>.tgz`)
+	Archive string
+}
+
+// Path returns the path of the file in chart for display
+func (s SubChart) Path(name string) string {
+	if s.Archive != "" {
+		return filepath.Join(s.Archive, name)
+	}
+
+	return filepath.Join(s.Dir, name)
+}
+
+// ReadFile reads file in the chart dir or chart archive, name is the path relative to chart root
+func (s SubChart) ReadFile(name string) ([]byte, error) {
+	if s.Archive == "" {
+		return ioutil.ReadFile(filepath.Join(s.Dir, name))
+	}
+
+	f, err := os.Open(s.Archive)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chart archive %q: %w", s.Archive, err)
+	}
+	defer func() { _ = gr.Close() }()
+
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			return nil, fmt.Errorf("failed to read chart archive %q: %w", s.Archive, err)
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		// files in chart archive are prefixed with chart name
+		parts := strings.SplitN(path.Clean(hdr.Name), "/", 2)
+		if len(parts) != 2 || parts[1] != path.Clean(filepath.ToSlash(name)) {
+			continue
+		}
+
+		return ioutil.ReadAll(tr)
+	}
+
+	return nil, &os.PathError{Op: "open", Path: s.Path(name), Err: os.ErrNotExist}
+}
+
+// readValues reads and merges values files in the chart, files not found are ignored
+func (s SubChart) readValues(files ...string) (map[string]interface{}, error) {
+	ret := make(map[string]interface{})
+	visited := make(map[string]struct{})
+	for _, f := range files {
+		if _, ok := visited[f]; ok {
+			continue
+		}
+		visited[f] = struct{}{}
+
+		data, err := s.ReadFile(f)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return nil, fmt.Errorf("failed to read values file %q: %w", s.Path(f), err)
+		}
+
+		values := make(map[string]interface{})
+		err = yaml.Unmarshal(data, &values)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse values file %q: %w", s.Path(f), err)
+		}
+
+		ret = mergeMaps(ret, values)
+	}
+
+	return ret, nil
+}
+
+// chartMetadata is the subset of Chart.yaml and requirements.yaml used by helm-stack
+type chartMetadata struct {
+	Name         string            `json:"name"`
+	Dependencies []chartDependency `json:"dependencies"`
+}
+
+type chartDependency struct {
+	Name      string   `json:"name"`
+	Alias     string   `json:"alias"`
+	Condition string   `json:"condition"`
+	Tags      []string `json:"tags"`
+}
+
+// SubCharts returns the chart itself (with empty name) and its sub charts, dependencies
+// defined in Chart.yaml (helm v3) or requirements.yaml (helm v2) are resolved with alias
+func (c ChartSpec) SubCharts(chartsDir, localChartsDir string) ([]SubChart, error) {
+	chartDir := c.Dir(chartsDir, localChartsDir, "")
+
+	result := []SubChart{{Dir: chartDir}}
+
+	files, err := ioutil.ReadDir(filepath.Join(chartDir, "charts"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+
+		return nil, fmt.Errorf("failed to check sub charts: %w", err)
+	}
+
+	var deps []chartDependency
+	for _, f := range []string{constant.ChartMetadataFile, constant.ChartRequirementsFile} {
+		md, err := readChartMetadata(result[0], f)
+		if err != nil {
+			return nil, err
+		}
+
+		deps = append(deps, md.Dependencies...)
+	}
+
+	var (
+		// chart name -> sub chart (dir or archive)
+		found = make(map[string]SubChart)
+		used  = make(map[string]bool)
+		names []string
+	)
+
+	for _, f := range files {
+		candidate := SubChart{Name: f.Name()}
+		switch {
+		case f.IsDir():
+			candidate.Dir = filepath.Join(chartDir, "charts", f.Name())
+		case strings.HasSuffix(f.Name(), ".tgz"):
+			candidate.Archive = filepath.Join(chartDir, "charts", f.Name())
+		default:
+			continue
+		}
+
+		md, err := readChartMetadata(candidate, constant.ChartMetadataFile)
+		if err != nil {
+			return nil, err
+		}
+
+		candidate.ChartName = md.Name
+		if candidate.ChartName == "" {
+			if candidate.Archive != "" {
+				// not a valid chart archive
+				continue
+			}
+
+			candidate.ChartName = f.Name()
+		}
+
+		if candidate.Archive != "" {
+			candidate.Name = candidate.ChartName
+		}
+
+		if existing, ok := found[candidate.ChartName]; ok && existing.Dir != "" {
+			// prefer unpacked sub chart
+			continue
+		} else if !ok {
+			names = append(names, candidate.ChartName)
+		}
+
+		found[candidate.ChartName] = candidate
+	}
+
+	for _, dep := range deps {
+		sub, ok := found[dep.Name]
+		if !ok {
+			// dependency not fetched
+			continue
+		}
+		used[dep.Name] = true
+
+		sub.Name = dep.Alias
+		if sub.Name == "" {
+			sub.Name = dep.Name
+		}
+
+		sub.Condition = dep.Condition
+		sub.Tags = dep.Tags
+
+		result = append(result, sub)
+	}
+
+	// sub charts not listed in dependencies are always included
+	for _, name := range names {
+		if used[name] {
+			continue
+		}
+
+		result = append(result, found[name])
+	}
+
+	return result, nil
+}
+
+// Enabled evaluates tags and condition of the sub chart against parent values
+// in the same way as helm
+func (s SubChart) Enabled(parentValues map[string]interface{}) bool {
+	if s.Name == "" {
+		return true
+	}
+
+	enabled := true
+
+	if len(s.Tags) != 0 {
+		tags, _ := parentValues["tags"].(map[string]interface{})
+
+		hasTrue, hasFalse := false, false
+		for _, t := range s.Tags {
+			v, ok := tags[t].(bool)
+			switch {
+			case ok && v:
+				hasTrue = true
+			case ok:
+				hasFalse = true
+			}
+		}
+
+		if !hasTrue && hasFalse {
+			enabled = false
+		}
+	}
+
+	for _, c := range strings.Split(s.Condition, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+
+		var current interface{} = parentValues
+		for _, p := range strings.Split(c, ".") {
+			m, ok := current.(map[string]interface{})
+			if !ok {
+				current = nil
+				break
+			}
+
+			current = m[p]
+		}
+
+		if v, ok := current.(bool); ok {
+			// first resolved condition wins
+			return v
+		}
+	}
+
+	return enabled
+}
+
+func readChartMetadata(c SubChart, file string) (*chartMetadata, error) {
+	md := new(chartMetadata)
+
+	data, err := c.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return md, nil
+		}
+
+		return nil, fmt.Errorf("failed to read chart metadata %q: %w", c.Path(file), err)
+	}
+
+	err = yaml.Unmarshal(data, md)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse chart metadata %q: %w", c.Path(file), err)
+	}
+
+	return md, nil
+}
+
+// EnabledSubCharts returns the chart and its sub charts enabled by chart default values, user
+// values of the parent chart and set values of the deployment
+func (e Environment) EnabledSubCharts(
+	chartsDir, localChartsDir, envDir string,
+	d DeploymentSpec,
+	chart *ChartSpec,
+) ([]SubChart, error) {
+	subCharts, err := chart.SubCharts(chartsDir, localChartsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(subCharts) == 1 {
+		return subCharts, nil
+	}
+
+	baseValuesFile := d.BaseValues
+	if baseValuesFile == "" {
+		baseValuesFile = constant.DefaultValuesFile
+	}
+
+	parentValues, err := subCharts[0].readValues(constant.DefaultValuesFile, baseValuesFile)
+	if err != nil {
+		return nil, err
+	}
+
+	globalValues, err := e.resolveGlobalValues(envDir)
+	if err != nil {
+		return nil, err
+	}
+	parentValues = mergeMaps(parentValues, globalValuesOverlay(globalValues))
+
+	valuesFile, data, err := e.readValuesFile(envDir, d, "")
+	switch {
+	case err == nil:
+		userValues := make(map[string]interface{})
+		if err = yaml.Unmarshal(data, &userValues); err != nil {
+			return nil, fmt.Errorf("failed to parse values from file %q: %w", valuesFile, err)
+		}
+
+		parentValues = mergeMaps(parentValues, userValues)
+	case !os.IsNotExist(err):
+		return nil, fmt.Errorf("failed to read values from file %q: %w", valuesFile, err)
+	}
+
+	if len(d.Set)+len(d.SetString)+len(d.SetFile) != 0 {
+		// conditions and tags are evaluated on final values as helm does
+		ops, err := e.resolveSetValues(envDir, d)
+		if err != nil {
+			return nil, err
+		}
+
+		parentValues, err = applySetValues(parentValues, ops)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var result []SubChart
+	for _, s := range subCharts {
+		if s.Enabled(parentValues) {
+			result = append(result, s)
+		}
+	}
+
+	return result, nil
+}
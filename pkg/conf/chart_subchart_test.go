@@ -0,0 +1,289 @@
+package conf
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/yaml"
+)
+
+func TestSubChart_Enabled(t *testing.T) {
+	tests := []struct {
+		name     string
+		sub      SubChart
+		values   string
+		expected bool
+	}{
+		{
+			name:     "Parent Chart",
+			sub:      SubChart{Condition: "foo.enabled"},
+			values:   `{foo: {enabled: false}}`,
+			expected: true,
+		},
+		{
+			name:     "No Condition Or Tags",
+			sub:      SubChart{Name: "foo"},
+			expected: true,
+		},
+		{
+			name:     "Condition False",
+			sub:      SubChart{Name: "foo", Condition: "foo.enabled"},
+			values:   `{foo: {enabled: false}}`,
+			expected: false,
+		},
+		{
+			name:     "First Resolved Condition Wins",
+			sub:      SubChart{Name: "foo", Condition: "foo.enabled, global.foo.enabled"},
+			values:   `{global: {foo: {enabled: false}}}`,
+			expected: false,
+		},
+		{
+			name:     "Condition Not Bool",
+			sub:      SubChart{Name: "foo", Condition: "foo.enabled"},
+			values:   `{foo: {enabled: "false"}}`,
+			expected: true,
+		},
+		{
+			name:     "Tags All False",
+			sub:      SubChart{Name: "foo", Tags: []string{"a", "b"}},
+			values:   `{tags: {a: false, b: false}}`,
+			expected: false,
+		},
+		{
+			name:     "Tags Any True",
+			sub:      SubChart{Name: "foo", Tags: []string{"a", "b"}},
+			values:   `{tags: {a: false, b: true}}`,
+			expected: true,
+		},
+		{
+			name:     "Condition Overrides Tags",
+			sub:      SubChart{Name: "foo", Condition: "foo.enabled", Tags: []string{"a"}},
+			values:   `{foo: {enabled: true}, tags: {a: false}}`,
+			expected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values := make(map[string]interface{})
+			if !assert.NoError(t, yaml.Unmarshal([]byte(test.values), &values)) {
+				return
+			}
+
+			assert.Equal(t, test.expected, test.sub.Enabled(values))
+		})
+	}
+}
+
+func TestEnvironment_resolveValues_SubCharts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-stack-test-*")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	var (
+		chartsDir      = filepath.Join(dir, "charts")
+		localChartsDir = filepath.Join(dir, "local-charts")
+		envDir         = filepath.Join(dir, "envs")
+	)
+
+	charts := map[string]*ChartSpec{
+		"foo@latest": {
+			Name:        "foo@latest",
+			ChartSource: &ChartSource{Local: &ChartFromLocalPath{}},
+		},
+	}
+	d := DeploymentSpec{Name: "default/foo", Chart: "foo@latest"}
+	e := Environment{Name: "test", Deployments: []DeploymentSpec{d}}
+
+	if !writeTestFiles(t, dir, map[string]string{
+		"local-charts/foo/latest/Chart.yaml": `
+name: foo
+dependencies:
+- name: bar
+  alias: baz
+  condition: baz.enabled
+- name: qux
+  tags: [extra]
+`,
+		"local-charts/foo/latest/values.yaml": `
+baz:
+  enabled: true
+tags:
+  extra: true
+`,
+		"local-charts/foo/latest/charts/bar/Chart.yaml":   "name: bar\n",
+		"local-charts/foo/latest/charts/bar/values.yaml":  "image: bar\nreplicas: 1\n",
+		"local-charts/foo/latest/charts/qux/Chart.yaml":   "name: qux\n",
+		"local-charts/foo/latest/charts/other/Chart.yaml": "name: other\n",
+
+		filepath.Join("envs", "test", d.Filename("")):    "tags:\n  extra: false\n",
+		filepath.Join("envs", "test", d.Filename("baz")): "replicas: 2\n",
+	}) {
+		return
+	}
+
+	subCharts, err := charts["foo@latest"].SubCharts(chartsDir, localChartsDir)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	chartDir := filepath.Join(localChartsDir, "foo", "latest")
+	assert.EqualValues(t, []SubChart{
+		{Dir: chartDir},
+		{
+			Name:      "baz",
+			ChartName: "bar",
+			Condition: "baz.enabled",
+			Dir:       filepath.Join(chartDir, "charts", "bar"),
+		},
+		{
+			Name:      "qux",
+			ChartName: "qux",
+			Tags:      []string{"extra"},
+			Dir:       filepath.Join(chartDir, "charts", "qux"),
+		},
+		{
+			Name:      "other",
+			ChartName: "other",
+			Dir:       filepath.Join(chartDir, "charts", "other"),
+		},
+	}, subCharts)
+
+	dv, err := e.resolveValues(chartsDir, localChartsDir, envDir, d, charts["foo@latest"])
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var names []string
+	for _, sub := range dv.subCharts {
+		names = append(names, sub.Name)
+	}
+	// qux disabled by tags in user values, other not listed in dependencies is always enabled
+	assert.EqualValues(t, []string{"", "baz", "other"}, names)
+
+	assert.EqualValues(t, map[string]interface{}{
+		"tags": map[string]interface{}{"extra": false},
+		"baz":  map[string]interface{}{"image": "bar", "replicas": float64(2)},
+	}, dv.Values)
+
+	assert.Equal(t, filepath.Join(envDir, "test", d.Filename("baz")), dv.sourceOf([]string{"baz", "replicas"}))
+	assert.Equal(t, "chart values", dv.sourceOf([]string{"baz", "image"}))
+
+	// conditions and tags are evaluated after set values applied
+	d.Set = map[string]interface{}{"baz.enabled": false, "tags.extra": true}
+	enabled, err := e.EnabledSubCharts(chartsDir, localChartsDir, envDir, d, charts["foo@latest"])
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	names = nil
+	for _, sub := range enabled {
+		names = append(names, sub.Name)
+	}
+	assert.EqualValues(t, []string{"", "qux", "other"}, names)
+}
+
+// newTestChartArchive creates a gzipped chart archive with files prefixed by chart name
+func newTestChartArchive(t *testing.T, chartName string, files map[string]string) []byte {
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+
+	for name, content := range files {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     chartName + "/" + name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}))
+
+		_, err := tw.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gw.Close())
+
+	return buf.Bytes()
+}
+
+func TestSubChart_Archive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-stack-test-*")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	var (
+		chartsDir      = filepath.Join(dir, "charts")
+		localChartsDir = filepath.Join(dir, "local-charts")
+		chartDir       = filepath.Join(localChartsDir, "foo", "latest")
+	)
+
+	chart := &ChartSpec{
+		Name:        "foo@latest",
+		ChartSource: &ChartSource{Local: &ChartFromLocalPath{}},
+	}
+
+	if !writeTestFiles(t, chartDir, map[string]string{
+		"Chart.yaml": `
+name: foo
+dependencies:
+- name: bar
+  alias: baz
+`,
+		"charts/bar-0.1.0.tgz": string(newTestChartArchive(t, "bar", map[string]string{
+			"Chart.yaml":             "name: bar\n",
+			"values.yaml":            "image: bar\n",
+			"templates/service.yaml": "kind: Service\n",
+		})),
+		// unpacked sub chart takes precedence
+		"charts/qux-0.1.0.tgz": string(newTestChartArchive(t, "qux", map[string]string{
+			"Chart.yaml": "name: qux\n",
+		})),
+		"charts/qux/Chart.yaml": "name: qux\n",
+		// not a chart archive
+		"charts/invalid.tgz": string(newTestChartArchive(t, "invalid", map[string]string{
+			"values.yaml": "foo: bar\n",
+		})),
+	}) {
+		return
+	}
+
+	subCharts, err := chart.SubCharts(chartsDir, localChartsDir)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	archive := filepath.Join(chartDir, "charts", "bar-0.1.0.tgz")
+	assert.EqualValues(t, []SubChart{
+		{Dir: chartDir},
+		{Name: "baz", ChartName: "bar", Archive: archive},
+		{Name: "qux", ChartName: "qux", Dir: filepath.Join(chartDir, "charts", "qux")},
+	}, subCharts)
+
+	sub := subCharts[1]
+	data, err := sub.ReadFile("values.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, "image: bar\n", string(data))
+
+	data, err = sub.ReadFile("./templates/service.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, "kind: Service\n", string(data))
+
+	_, err = sub.ReadFile("values.schema.json")
+	assert.True(t, os.IsNotExist(err))
+	assert.Contains(t, err.Error(), filepath.Join(archive, "values.schema.json"))
+
+	values, err := sub.readValues("values.yaml", "values-production.yaml")
+	assert.NoError(t, err)
+	assert.EqualValues(t, map[string]interface{}{"image": "bar"}, values)
+}
@@ -0,0 +1,57 @@
+package conf
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"arhat.dev/pkg/exechelper"
+)
+
+// CRDEstablishedTimeout is the max time to wait for applied custom resource definitions
+// to become established
+const CRDEstablishedTimeout = 2 * time.Minute
+
+// applyCRDs runs kubectl action with custom resource definitions, and waits for them to become
+// established after applied
+func (e Environment) applyCRDs(ctx context.Context, kubectlCmd, action []string, dryRunArg, crdsFile string) error {
+	crdsCmd := assembleCommandWithoutEmptyString(kubectlCmd,
+		append(append([]string{}, action...), dryRunArg, "--filename", crdsFile)...)
+	err := runKubectl(ctx, crdsCmd)
+	if err != nil {
+		return fmt.Errorf("failed to %s crds: %w", action[0], err)
+	}
+
+	if action[0] != "apply" || dryRunArg != "" {
+		return nil
+	}
+
+	waitCmd := assembleCommandWithoutEmptyString(kubectlCmd,
+		"wait", "--for", "condition=Established", "--timeout", CRDEstablishedTimeout.String(),
+		"--filename", crdsFile,
+	)
+	err = runKubectl(ctx, waitCmd)
+	if err != nil {
+		return fmt.Errorf("failed to wait for crds established: %w", err)
+	}
+
+	return nil
+}
+
+func runKubectl(ctx context.Context, cmd []string) error {
+	fmt.Println("Executing:", strings.Join(cmd, " "))
+	proc, err := exechelper.Do(exechelper.Spec{
+		Context: ctx,
+		Command: cmd,
+		Stdout:  os.Stdout,
+		Stderr:  os.Stdout,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to execute kubectl command: %w", err)
+	}
+
+	_, err = proc.Wait()
+	return err
+}
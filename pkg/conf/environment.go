@@ -1,18 +1,19 @@
 package conf
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"arhat.dev/pkg/exechelper"
-	"arhat.dev/pkg/iohelper"
 	"go.uber.org/multierr"
-	"sigs.k8s.io/yaml"
 
 	"arhat.dev/helm-stack/pkg/constant"
 )
@@ -21,6 +22,52 @@ type Environment struct {
 	Name        string           `json:"name" yaml:"name"`
 	KubeContext string           `json:"kubeContext" yaml:"kubeContext"`
 	Deployments []DeploymentSpec `json:"deployments" yaml:"deployments"`
+
+	// Labels of this environment, available in values templates
+	Labels map[string]string `json:"labels" yaml:"labels"`
+
+	// GlobalValues are merged into `global` values of every deployment in this environment
+	// with the lowest precedence among user values, helm passes them to all sub charts
+	GlobalValues map[string]interface{} `json:"globalValues" yaml:"globalValues"`
+
+	// ManifestsLayout of generated manifests, one of `single` (default, one file per deployment)
+	// and `split` (one dir per deployment, one file per resource)
+	ManifestsLayout string `json:"manifestsLayout" yaml:"manifestsLayout"`
+
+	// CommonLabels and CommonAnnotations are added to all rendered resources of this environment
+	CommonLabels      map[string]string `json:"commonLabels" yaml:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations" yaml:"commonAnnotations"`
+
+	// BuiltinLabels adds `helm-stack.arhat.dev/environment` and `helm-stack.arhat.dev/deployment`
+	// labels to all rendered resources
+	BuiltinLabels bool `json:"builtinLabels" yaml:"builtinLabels"`
+
+	// CommonMetadataInPodTemplates also adds common labels and annotations to pod templates
+	CommonMetadataInPodTemplates bool `json:"commonMetadataInPodTemplates" yaml:"commonMetadataInPodTemplates"`
+
+	// KubeVersion and APIVersions are capabilities of the cluster used to render charts, merged over
+	// the capabilities file (`helm-stack capabilities capture`)
+	KubeVersion string   `json:"kubeVersion" yaml:"kubeVersion"`
+	APIVersions []string `json:"apiVersions" yaml:"apiVersions"`
+
+	// CapabilitiesFile contains captured capabilities of the cluster, path relative to the environment
+	// values dir, defaults to `capabilities.yaml`
+	CapabilitiesFile string `json:"capabilitiesFile" yaml:"capabilitiesFile"`
+
+	// ImageRewrites are rules to rewrite container images in rendered resources, the first matched
+	// rule applies
+	ImageRewrites []ImageRewriteSpec `json:"imageRewrites" yaml:"imageRewrites"`
+
+	// ImageDigestsFile is a yaml map of images (after rewrite) to digests used to pin images, path
+	// relative to the environment values dir
+	ImageDigestsFile string `json:"imageDigestsFile" yaml:"imageDigestsFile"`
+
+	// Normalize generated manifests
+	Normalize ManifestsNormalization `json:"normalize" yaml:"normalize"`
+
+	// GlobalValuesFile contains global values (the content of `global` key), path relative to
+	// the environment values dir, inline GlobalValues take precedence
+	GlobalValuesFile string `json:"globalValuesFile" yaml:"globalValuesFile"`
 }
 
 func (e Environment) ValuesDir(envDir string) string {
@@ -57,11 +104,41 @@ func (e Environment) Validate(charts map[string]*ChartSpec) error {
 		err = multierr.Append(err, d.Validate(charts))
 	}
 
-	return nil
+	switch e.ManifestsLayout {
+	case "", ManifestsLayoutSingle, ManifestsLayoutSplit:
+	default:
+		err = multierr.Append(err, fmt.Errorf("invalid manifestsLayout %q, expecting one of %q, %q",
+			e.ManifestsLayout, ManifestsLayoutSingle, ManifestsLayoutSplit))
+	}
+
+	for i, r := range e.ImageRewrites {
+		if rErr := r.Validate(); rErr != nil {
+			err = multierr.Append(err, fmt.Errorf("invalid imageRewrites[%d]: %w", i, rErr))
+		}
+	}
+
+	return err
 }
 
+const (
+	ManifestsLayoutSingle = "single"
+	ManifestsLayoutSplit  = "split"
+)
+
+// ManifestsStagingDirPrefix is the name prefix of temporary dirs for manifests generation
+const ManifestsStagingDirPrefix = ".manifests-staging-"
+
+// ManifestsBackupDirPrefix is the name prefix of previous manifests dirs moved aside when replaced,
+// they are only left when gen is interrupted
+const ManifestsBackupDirPrefix = "manifests.old-"
+
+// UpstreamValuesDir is the dir in environment values dir keeping upstream values last copied or merged
+// into values files, values keys removed by user are not added again by `ensure --refresh-values`
+const UpstreamValuesDir = ".upstream-values"
+
 func (e Environment) Ensure(
 	ctx context.Context,
+	refreshValues bool,
 	chartsDir, localChartsDir, envDir string,
 	charts map[string]*ChartSpec,
 ) error {
@@ -79,278 +156,506 @@ func (e Environment) Ensure(
 			return fmt.Errorf("chart %s not found", d.Chart)
 		}
 
-		subChartNames, err := chart.SubChartNames(chartsDir, localChartsDir)
-		if err != nil {
-			return fmt.Errorf("failed to check sub charts")
-		}
-
 		cDir := e.CustomManifestsDir(envDir, &e.Deployments[i])
 		err = os.MkdirAll(cDir, 0755)
 		if err != nil {
 			return fmt.Errorf("failed to create custom manifests dir %q: %w", cDir, err)
 		}
 
-		for _, subChartName := range append([]string{""}, subChartNames...) {
-			destValuesFile := filepath.Join(valuesDir, d.Filename(subChartName))
+		// ensure values file of the parent chart first, sub charts enabled or not are
+		// determined by parent values
+		subCharts, err := chart.SubCharts(chartsDir, localChartsDir)
+		if err != nil {
+			return err
+		}
+
+		err = e.ensureValuesFile(refreshValues, valuesDir, d, subCharts[0])
+		if err != nil {
+			return err
+		}
 
-			_, err := os.Stat(destValuesFile)
-			if err == nil {
-				// values file exists
-				continue
-			}
+		subCharts, err = e.EnabledSubCharts(chartsDir, localChartsDir, envDir, d, chart)
+		if err != nil {
+			return err
+		}
 
-			if !errors.Is(err, os.ErrNotExist) {
-				return fmt.Errorf("failed to probe values file %q: %w", destValuesFile, err)
+		for _, sub := range subCharts[1:] {
+			err = e.ensureValuesFile(refreshValues, valuesDir, d, sub)
+			if err != nil {
+				return err
 			}
+		}
+	}
+
+	return nil
+}
+
+// ensureValuesFile copies values file from the (sub) chart if not exists, or merges new values
+// keys from the chart into existing values file when refreshValues is set
+func (e Environment) ensureValuesFile(
+	refreshValues bool,
+	valuesDir string,
+	d DeploymentSpec,
+	sub SubChart,
+) error {
+	_, err := os.Stat(filepath.Join(valuesDir, d.ValuesTemplateFilename(sub.Name)))
+	if err == nil {
+		// values template file exists, managed by user
+		return nil
+	}
+
+	destValuesFile := filepath.Join(valuesDir, d.Filename(sub.Name))
+	upstreamSnapshotFile := filepath.Join(valuesDir, UpstreamValuesDir, d.Filename(sub.Name))
 
-			baseValuesFile := d.BaseValues
-			if baseValuesFile == "" {
-				baseValuesFile = constant.DefaultValuesFile
+	baseValuesFile := d.BaseValues
+	if baseValuesFile == "" {
+		baseValuesFile = constant.DefaultValuesFile
+	}
+
+	_, err = os.Stat(destValuesFile)
+	if err == nil {
+		// values file exists
+		if !refreshValues {
+			return nil
+		}
+
+		upstreamValuesFile := baseValuesFile
+		upstreamData, err := sub.ReadFile(upstreamValuesFile)
+		if err != nil && sub.Name != "" && os.IsNotExist(err) {
+			// fallback to values.yaml for sub chart
+			upstreamValuesFile = constant.DefaultValuesFile
+			upstreamData, err = sub.ReadFile(upstreamValuesFile)
+		}
+
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// no upstream values
+				return nil
 			}
 
-			srcValuesFile := filepath.Join(chart.Dir(chartsDir, localChartsDir, subChartName), baseValuesFile)
-			if err = iohelper.CopyFile(srcValuesFile, destValuesFile); err == nil {
+			return fmt.Errorf("failed to read upstream values file %q: %w", sub.Path(upstreamValuesFile), err)
+		}
+
+		added, err := refreshValuesFile(
+			destValuesFile, upstreamSnapshotFile, sub.Path(upstreamValuesFile), upstreamData,
+		)
+		if err != nil {
+			return err
+		}
+
+		for _, path := range added {
+			fmt.Printf("added values key %q to %q\n", formatValuesPath(path), destValuesFile)
+		}
+
+		return nil
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to probe values file %q: %w", destValuesFile, err)
+	}
+
+	srcValuesFiles := []string{baseValuesFile}
+	if sub.Name != "" && baseValuesFile != constant.DefaultValuesFile {
+		// fallback to values.yaml for sub chart
+		srcValuesFiles = append(srcValuesFiles, constant.DefaultValuesFile)
+	}
+
+	for _, srcValuesFile := range srcValuesFiles {
+		data, err := sub.ReadFile(srcValuesFile)
+		if err != nil {
+			if os.IsNotExist(err) {
 				continue
 			}
 
-			if subChartName != "" {
-				// this is a sub chart, may not contain target values file
-				subChartValuesFiles := []string{
-					filepath.Join(chart.Dir(chartsDir, localChartsDir, subChartName), baseValuesFile),
-				}
-				if baseValuesFile != constant.DefaultValuesFile {
-					// fallback to values.yaml
-					subChartValuesFiles = append(subChartValuesFiles,
-						filepath.Join(chart.Dir(chartsDir, localChartsDir, subChartName), constant.DefaultValuesFile),
-					)
-				}
-				for _, srcValuesFile := range subChartValuesFiles {
-					err = iohelper.CopyFile(srcValuesFile, destValuesFile)
-					if os.IsNotExist(err) {
-						// values file not found in sub chart, just ignore it
-						err = nil
-					}
-				}
-			}
+			return fmt.Errorf("failed to read values file %q: %w", sub.Path(srcValuesFile), err)
+		}
 
-			if err != nil {
-				return fmt.Errorf("failed to copy values file %q: %w", srcValuesFile, err)
-			}
+		err = ioutil.WriteFile(destValuesFile, data, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to copy values file %q: %w", sub.Path(srcValuesFile), err)
 		}
+
+		return writeUpstreamValuesSnapshot(upstreamSnapshotFile, data)
 	}
 
-	return nil
+	if sub.Name != "" {
+		// values file not found in sub chart, just ignore it
+		return nil
+	}
+
+	return fmt.Errorf("failed to copy values file %q: %w", sub.Path(baseValuesFile), os.ErrNotExist)
+}
+
+// GenOptions are options for manifests generation
+type GenOptions struct {
+	// Strict to fail when user values contain keys unknown to the chart
+	Strict bool
+
+	// RefResolver to resolve values references (e.g. `ref+file://path/to/secret`),
+	// defaults to NewValuesRefResolver(false)
+	RefResolver *ValuesRefResolver
+
+	// Renderer to render charts, defaults to NewExecRenderer()
+	Renderer Renderer
+
+	// Limiter limits concurrent renders, deployments are rendered one by one if not set
+	Limiter JobLimiter
+
+	// Cache for rendered manifests, manifests are always rendered if not set, deployments with
+	// values refs resolved are never cached
+	Cache *RenderCache
+	// Force to render manifests even if found in Cache
+	Force bool
+
+	// Stats counts rendered and reused deployments (optional)
+	Stats *GenStats
 }
 
-// nolint:gocyclo
+// genOutputMu serializes logs of deployments generated concurrently
+var genOutputMu = new(sync.Mutex)
+
 func (e Environment) Gen(
 	ctx context.Context,
 	chartsDir, localChartsDir, envDir string,
 	charts map[string]*ChartSpec,
+	opts GenOptions,
 ) error {
 	manifestsDir := e.ManifestsDir(envDir)
 
-	_ = os.RemoveAll(manifestsDir)
+	if opts.RefResolver == nil {
+		opts.RefResolver = NewValuesRefResolver(false)
+	}
 
-	err := os.MkdirAll(manifestsDir, 0755)
-	if err != nil && !os.IsExist(err) {
-		return fmt.Errorf("failed to ensure manifests dir")
+	if opts.Renderer == nil {
+		opts.Renderer = NewExecRenderer()
 	}
 
-	for _, d := range e.Deployments {
-		chart := charts[d.Chart]
-		if chart == nil {
-			return fmt.Errorf("chart %s not found", d.Chart)
-		}
+	limiter := opts.Limiter
+	if limiter == nil {
+		limiter = NewJobLimiter(1)
+	}
 
-		subChartNames, err := chart.SubChartNames(chartsDir, localChartsDir)
-		if err != nil {
-			return fmt.Errorf("failed to check sub charts")
-		}
+	err := os.MkdirAll(e.ValuesDir(envDir), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to ensure environment values dir: %w", err)
+	}
 
-		var (
-			namespace, name = d.NamespaceAndName()
-			manifestFile    = filepath.Join(manifestsDir, d.Filename(""))
-			baseValuesFile  = d.BaseValues
-		)
+	// render into staging dir, only replace manifests dir when all deployments succeeded
+	stagingDir, err := ioutil.TempDir(e.ValuesDir(envDir), ManifestsStagingDirPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to create manifests staging dir: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(stagingDir) }()
 
-		if baseValuesFile == "" {
-			baseValuesFile = constant.DefaultValuesFile
-		}
+	err = os.Chmod(stagingDir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to set mode of manifests staging dir: %w", err)
+	}
+
+	var (
+		wg     = new(sync.WaitGroup)
+		mu     = new(sync.Mutex)
+		result error
+	)
 
-		cmd := []string{
-			"helm", "template", "--namespace", namespace, "--debug",
-			"--values", filepath.Join(chart.Dir(chartsDir, localChartsDir, ""), baseValuesFile),
-			"--set", "fullnameOverride=" + name,
+	for i := range e.Deployments {
+		if !limiter.Acquire(ctx) {
+			result = multierr.Append(result, fmt.Errorf("manifests generation canceled: %w", ctx.Err()))
+			break
 		}
 
-		if !isHelmV2() {
-			cmd = append(cmd, "--no-hooks")
-			if d.ExcludeChartCRDs {
-				cmd = append(cmd, "--skip-crds")
-			} else {
-				cmd = append(cmd, "--include-crds")
+		wg.Add(1)
+		go func(d DeploymentSpec) {
+			defer func() {
+				limiter.Release()
+				wg.Done()
+			}()
+
+			logBuf := new(bytes.Buffer)
+			err := e.genDeployment(ctx, chartsDir, localChartsDir, envDir, stagingDir, charts, d, opts, logBuf)
+
+			genOutputMu.Lock()
+			_, _ = io.Copy(os.Stdout, logBuf)
+			genOutputMu.Unlock()
+
+			if err != nil {
+				mu.Lock()
+				result = multierr.Append(result, err)
+				mu.Unlock()
 			}
-			cmd = append(cmd, name, chart.Dir(chartsDir, localChartsDir, ""))
-		} else {
-			cmd = append(cmd, chart.Dir(chartsDir, localChartsDir, ""), name)
+		}(e.Deployments[i])
+	}
+
+	wg.Wait()
+
+	if result != nil {
+		// keep previous manifests untouched
+		return result
+	}
+
+	return replaceDir(stagingDir, manifestsDir, ManifestsBackupDirPrefix)
+}
+
+// replaceDir replaces dst dir with src dir, dst is moved aside (as backupPrefix with random suffix
+// in the same parent dir) before src is renamed to dst, and restored if failed
+func replaceDir(src, dst, backupPrefix string) error {
+	backup := filepath.Join(filepath.Dir(dst), backupPrefix+generateRandomName(dst)[:8])
+
+	err := os.Rename(dst, backup)
+	switch {
+	case err == nil:
+	case os.IsNotExist(err):
+		backup = ""
+	default:
+		return fmt.Errorf("failed to move aside dir %q: %w", dst, err)
+	}
+
+	err = os.Rename(src, dst)
+	if err != nil {
+		if backup != "" {
+			_ = os.Rename(backup, dst)
 		}
 
-		allValues := make(map[string]interface{})
-		for _, subChartName := range append([]string{""}, subChartNames...) {
-			var (
-				valuesFile = filepath.Join(e.ValuesDir(envDir), d.Filename(subChartName))
-			)
+		return fmt.Errorf("failed to rename dir %q to %q: %w", src, dst, err)
+	}
 
-			currentValues := map[string]interface{}{}
+	if backup != "" {
+		_ = os.RemoveAll(backup)
+	}
 
-			subChartValuesFiles := []string{
-				filepath.Join(chart.Dir(chartsDir, localChartsDir, subChartName), baseValuesFile),
-			}
-			if subChartName != "" && baseValuesFile != constant.DefaultValuesFile {
-				// fallback to values.yaml
-				subChartValuesFiles = append(subChartValuesFiles,
-					filepath.Join(chart.Dir(chartsDir, localChartsDir, subChartName), constant.DefaultValuesFile),
-				)
-			}
+	return nil
+}
 
-			data, fErr := ioutil.ReadFile(valuesFile)
-			if fErr != nil {
-				if subChartName == "" || !os.IsNotExist(fErr) {
-					return fmt.Errorf("failed to read values from file %q: %w", valuesFile, fErr)
-				}
+func (e Environment) genDeployment(
+	ctx context.Context,
+	chartsDir, localChartsDir, envDir, manifestsDir string,
+	charts map[string]*ChartSpec,
+	d DeploymentSpec,
+	opts GenOptions,
+	log io.Writer,
+) error {
+	chart := charts[d.Chart]
+	if chart == nil {
+		return fmt.Errorf("chart %s not found", d.Chart)
+	}
 
-				// some sub chart may not contain any values file, check if has values in its dir
-				for _, f := range subChartValuesFiles {
-					_, fErr = os.Stat(f)
-					if fErr == nil {
-						return fmt.Errorf("inconsistent values file, please run `helm-stack ensure` to fix it")
-					}
-				}
+	var (
+		namespace, _   = d.NamespaceAndName()
+		baseValuesFile = d.BaseValues
+	)
 
-				continue
-			}
+	if baseValuesFile == "" {
+		baseValuesFile = constant.DefaultValuesFile
+	}
 
-			if mErr := yaml.Unmarshal(data, &currentValues); err != nil {
-				return fmt.Errorf("failed to parse values from file %q: %w", valuesFile, mErr)
-			}
+	dv, err := e.resolveValues(chartsDir, localChartsDir, envDir, d, chart)
+	if err != nil {
+		return err
+	}
 
-			if subChartName != "" {
-				// get sub chart base values
-				for _, subChartBaseValuesFile := range subChartValuesFiles {
-					data, fErr = ioutil.ReadFile(subChartBaseValuesFile)
-					// ignore this error
-					if fErr != nil {
-						if !os.IsNotExist(fErr) {
-							return fmt.Errorf(
-								"failed to check sub chart base values %q: %w",
-								subChartBaseValuesFile, fErr,
-							)
-						}
-
-						continue
-					}
-
-					subChartBaseValues := make(map[string]interface{})
-					if fErr == nil {
-						if mErr := yaml.Unmarshal(data, &subChartBaseValues); mErr != nil {
-							return fmt.Errorf(
-								"failed to parse sub chart base values from file %q: %w",
-								valuesFile, mErr,
-							)
-						}
-
-						switch t := allValues[subChartName].(type) {
-						case map[string]interface{}:
-							allValues[subChartName] = mergeMaps(t, subChartBaseValues)
-						case nil:
-							allValues[subChartName] = subChartBaseValues
-						default:
-							return fmt.Errorf("invalid sub chart values in main values file: %v", t)
-						}
-					}
-				}
-			}
+	if opts.Strict {
+		err = e.lintValues(chartsDir, localChartsDir, d, chart, dv)
+		if err != nil {
+			return fmt.Errorf("unknown values for deployment %q: %w", d.Name, err)
+		}
+	}
 
-			if subChartName == "" {
-				allValues = mergeMaps(allValues, currentValues)
-			} else {
-				// merge sub chart values
-				switch t := allValues[subChartName].(type) {
-				case map[string]interface{}:
-					allValues[subChartName] = mergeMaps(t, currentValues)
-				case nil:
-					allValues[subChartName] = currentValues
-				default:
-					return fmt.Errorf("invalid sub chart values in main values file: %v", t)
-				}
-			}
+	values, err := opts.RefResolver.ResolveValues(ctx, e.ValuesDir(envDir), dv.Values)
+	if err != nil {
+		return fmt.Errorf("failed to resolve values refs for deployment %q: %w", d.Name, err)
+	}
+
+	// violations at refs replaced with placeholders are ignored
+	refsResolved := opts.RefResolver.ResolvesSecrets(dv.Values)
+	err = e.validateValues(chartsDir, localChartsDir, d, chart, dv, values, !refsResolved)
+	if err != nil {
+		return fmt.Errorf("invalid values for deployment %q: %w", d.Name, err)
+	}
+
+	if opts.Cache != nil && opts.RefResolver.ResolvesSecrets(dv.Values) {
+		// do not store resolved secrets in cache
+		opts.Cache = nil
+	}
+
+	capabilities, err := e.resolveCapabilities(envDir)
+	if err != nil {
+		return err
+	}
+
+	chartDir := chart.Dir(chartsDir, localChartsDir, "")
+	req := &RenderRequest{
+		Namespace:   namespace,
+		ReleaseName: d.GetReleaseName(),
+		ChartDir:    chartDir,
+		ValuesFiles: []string{filepath.Join(chartDir, baseValuesFile)},
+		Values:      values,
+		Set:         d.NameOverrideValues(),
+		IncludeCRDs: !d.ExcludeChartCRDs,
+		Hooks:       d.Hooks,
+		KubeVersion: capabilities.KubeVersion,
+		APIVersions: capabilities.APIVersions,
+		Log:         log,
+	}
+
+	manifests, err := e.render(ctx, req, opts)
+	if err != nil {
+		return fmt.Errorf("failed to render deployment %q: %w", d.Name, err)
+	}
+
+	manifests, err = e.postRender(envDir, d, manifests)
+	if err != nil {
+		return fmt.Errorf("failed to post render deployment %q: %w", d.Name, err)
+	}
+
+	return e.writeManifests(manifestsDir, &d, manifests)
+}
+
+// postRender modifies rendered manifests in Go, manifests are returned as is
+// if there is nothing to do
+func (e Environment) postRender(envDir string, d DeploymentSpec, manifests []byte) ([]byte, error) {
+	docs, err := parseManifests(manifests)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace, _ := d.NamespaceAndName()
+	injectNamespace(docs, namespace)
+
+	if len(d.Patches) != 0 {
+		err = e.applyPatches(envDir, d, docs)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		valuesBytes, mErr := yaml.Marshal(allValues)
-		if mErr != nil {
-			return fmt.Errorf("failed to marshal values: %w", mErr)
+	manifests, err = encodeManifests(docs)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.Kustomize != "" {
+		dir := d.Kustomize
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(e.ValuesDir(envDir), dir)
 		}
 
-		tempValuesFile, fErr := ioutil.TempFile(os.TempDir(), "helm-stack-values-*.yaml")
-		if fErr != nil {
-			return fmt.Errorf("failed to create temporary values file: %w", fErr)
+		manifests, err = kustomizeBuild(dir, map[string][]byte{
+			filepath.Join(dir, KustomizeHelmOutputFile): manifests,
+		})
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		err = func() error {
-			defer func() {
-				// best effort
-				_ = os.Remove(tempValuesFile.Name())
-			}()
+	if m := e.commonMetadata(d); m.enabled() {
+		docs, err = parseManifests(manifests)
+		if err != nil {
+			return nil, err
+		}
 
-			err = func() error {
-				defer func() {
-					_ = tempValuesFile.Close()
-				}()
+		addCommonMetadata(docs, m)
+		manifests, err = encodeManifests(docs)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-				_, err2 := tempValuesFile.Write(valuesBytes)
-				if err2 != nil {
-					return fmt.Errorf("failed to write temporary values file %q: %w", tempValuesFile.Name(), err2)
-				}
+	rewriter, err := e.imageRewriter(envDir)
+	if err != nil {
+		return nil, err
+	}
 
-				return nil
-			}()
-			if err != nil {
-				return err
-			}
+	if rewriter != nil {
+		docs, err = parseManifests(manifests)
+		if err != nil {
+			return nil, err
+		}
 
-			cmd = assembleCommandWithoutEmptyString(cmd, "--values", tempValuesFile.Name())
+		rewriteImages(docs, rewriter)
+		manifests, err = encodeManifests(docs)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-			fmt.Println("Executing:", strings.Join(cmd, " "))
-			manifestFile, err2 := os.OpenFile(manifestFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
-			if err2 != nil {
-				return fmt.Errorf("failed to open manifest file: %w", err2)
-			}
-			defer func() { _ = manifestFile.Close() }()
+	if e.Normalize.Enabled() {
+		docs, err = parseManifests(manifests)
+		if err != nil {
+			return nil, err
+		}
 
-			proc, err2 := exechelper.Do(exechelper.Spec{
-				Context: ctx,
-				Command: cmd,
-				Stdout:  manifestFile,
-				Stderr:  os.Stdout,
-			})
-			if err2 != nil {
-				return fmt.Errorf("failed to execute helm template command: %w", err2)
-			}
-			_, err2 = proc.Wait()
-			if err2 != nil {
-				return fmt.Errorf("failed to generate manifest: %w", err2)
-			}
+		manifests, err = encodeManifests(normalizeManifests(docs, e.Normalize))
+		if err != nil {
+			return nil, err
+		}
+	}
 
-			return nil
-		}()
+	return manifests, nil
+}
+
+// render manifests with renderer, reuse cached manifests if possible
+func (e Environment) render(ctx context.Context, req *RenderRequest, opts GenOptions) ([]byte, error) {
+	var (
+		cacheKey string
+		err      error
+	)
 
+	// cache errors are not fatal, manifests are rendered and not cached
+	if opts.Cache != nil {
+		cacheKey, err = opts.Cache.Key(ctx, opts.Renderer, req)
 		if err != nil {
-			return err
+			_, _ = fmt.Fprintf(req.Log, "Not caching manifests for %s/%s: failed to generate cache key: %v\n",
+				req.Namespace, req.ReleaseName, err)
+			opts.Cache = nil
 		}
 	}
 
-	return nil
+	if opts.Cache != nil && !opts.Force {
+		if data, ok := opts.Cache.Get(cacheKey); ok {
+			_, _ = fmt.Fprintf(req.Log, "Reusing cached manifests for %s/%s\n", req.Namespace, req.ReleaseName)
+			opts.Stats.addReused()
+			return data, nil
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	err = opts.Renderer.Render(ctx, req, buf)
+	if err != nil {
+		return nil, err
+	}
+	opts.Stats.addRendered()
+
+	if opts.Cache != nil {
+		err = opts.Cache.Put(cacheKey, buf.Bytes())
+		if err != nil {
+			_, _ = fmt.Fprintf(req.Log, "Failed to cache manifests for %s/%s: %v\n",
+				req.Namespace, req.ReleaseName, err)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// readValuesFile reads values for the deployment (sub) chart, values template file takes
+// precedence over plain values file and is rendered before returned
+func (e Environment) readValuesFile(
+	envDir string,
+	d DeploymentSpec,
+	subChartName string,
+) (valuesFile string, data []byte, err error) {
+	valuesFile = filepath.Join(e.ValuesDir(envDir), d.ValuesTemplateFilename(subChartName))
+	data, err = ioutil.ReadFile(valuesFile)
+	switch {
+	case err == nil:
+		data, err = renderValuesTemplate(valuesFile, data, newValuesTemplateContext(e, d, subChartName))
+		return valuesFile, data, err
+	case !os.IsNotExist(err):
+		return valuesFile, nil, err
+	}
+
+	valuesFile = filepath.Join(e.ValuesDir(envDir), d.Filename(subChartName))
+	data, err = ioutil.ReadFile(valuesFile)
+	return valuesFile, data, err
 }
 
 func (e Environment) Apply(ctx context.Context, dryRunArg, envDir string, charts map[string]*ChartSpec) error {
@@ -366,19 +671,12 @@ func (e Environment) Apply(ctx context.Context, dryRunArg, envDir string, charts
 
 		s := d.GetState()
 
-		switch {
-		case s.Present && !s.CRDPresent:
-			// TODO: filter out crd
-			fallthrough
-		case s.Present && s.CRDPresent:
+		if s.Present {
 			action = []string{"apply"}
 			if s.DisableValidation {
 				action = append(action, "--validate=false")
 			}
-		case !s.Present && !s.CRDPresent:
-			// TODO: filter out crd
-			fallthrough
-		case !s.Present && s.CRDPresent:
+		} else {
 			action = []string{"delete", "--ignore-not-found=true"}
 		}
 
@@ -402,28 +700,63 @@ func (e Environment) Apply(ctx context.Context, dryRunArg, envDir string, charts
 			_, _ = proc.Wait()
 		}
 
-		manifestFile := filepath.Join(e.ManifestsDir(envDir), d.Filename(""))
+		crdsFile := e.crdsPath(e.ManifestsDir(envDir), &e.Deployments[i])
+		_, err = os.Stat(crdsFile)
+		hasCRDs := err == nil
 
-		applyCmd := assembleCommandWithoutEmptyString(kubectlCmd,
-			append(action, dryRunArg, "--filename", manifestFile)...)
-		if !chart.NamespaceInTemplate {
-			applyCmd = append(applyCmd, "--namespace", namespace)
+		// crds are applied before other manifests, and only deleted when explicitly requested
+		if hasCRDs && s.Present && s.CRDPresent {
+			err = e.applyCRDs(ctx, kubectlCmd, action, dryRunArg, crdsFile)
+			if err != nil {
+				return err
+			}
 		}
 
-		fmt.Println("Executing:", strings.Join(applyCmd, " "))
-		proc, err = exechelper.Do(exechelper.Spec{
-			Context: ctx,
-			Command: applyCmd,
-			Stdout:  os.Stdout,
-			Stderr:  os.Stdout,
-		})
+		preHooks, postHooks := HookPhasePreApply, HookPhasePostApply
+		if !s.Present {
+			preHooks, postHooks = HookPhasePreDelete, HookPhasePostDelete
+		}
+
+		hooksDir := e.hooksPath(e.ManifestsDir(envDir), &e.Deployments[i])
+		err = e.runHooks(ctx, kubectlCmd, dryRunArg, hooksDir, preHooks)
 		if err != nil {
-			return fmt.Errorf("failed to execute kubectl apply command: %w", err)
+			return err
+		}
+
+		// not generated when the chart contains only crds
+		manifestFile := e.manifestsPath(e.ManifestsDir(envDir), &e.Deployments[i])
+		if hasManifestFiles(manifestFile) {
+			// namespace has been set in manifests of namespaced resources when generated
+			applyCmd := assembleCommandWithoutEmptyString(kubectlCmd,
+				append(action, dryRunArg, "--filename", manifestFile)...)
+
+			fmt.Println("Executing:", strings.Join(applyCmd, " "))
+			proc, err = exechelper.Do(exechelper.Spec{
+				Context: ctx,
+				Command: applyCmd,
+				Stdout:  os.Stdout,
+				Stderr:  os.Stdout,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to execute kubectl apply command: %w", err)
+			}
+			_, err = proc.Wait()
+
+			if err != nil {
+				return fmt.Errorf("failed to execute kubectl apply: %w", err)
+			}
 		}
-		_, err = proc.Wait()
 
+		err = e.runHooks(ctx, kubectlCmd, dryRunArg, hooksDir, postHooks)
 		if err != nil {
-			return fmt.Errorf("failed to execute kubectl apply: %w", err)
+			return err
+		}
+
+		if hasCRDs && !s.Present && !s.CRDPresent {
+			err = e.applyCRDs(ctx, kubectlCmd, action, dryRunArg, crdsFile)
+			if err != nil {
+				return err
+			}
 		}
 
 		cDir := e.CustomManifestsDir(envDir, &e.Deployments[i])
@@ -432,8 +765,8 @@ func (e Environment) Apply(ctx context.Context, dryRunArg, envDir string, charts
 			continue
 		}
 
-		files, err := ioutil.ReadDir(cDir)
-		if err != nil && !os.IsNotExist(err) {
+		files, cleanup, err := e.customManifests(envDir, &e.Deployments[i])
+		if err != nil {
 			return fmt.Errorf("failed to check custom manifests: %w", err)
 		}
 
@@ -441,8 +774,10 @@ func (e Environment) Apply(ctx context.Context, dryRunArg, envDir string, charts
 			continue
 		}
 
-		customApply := assembleCommandWithoutEmptyString(kubectlCmd,
-			append(action, dryRunArg, "--recursive", "--filename", cDir)...)
+		customApply := assembleCommandWithoutEmptyString(kubectlCmd, append(action, dryRunArg)...)
+		for _, f := range files {
+			customApply = append(customApply, "--filename", f)
+		}
 		fmt.Println("Executing:", strings.Join(customApply, " "))
 		proc, err = exechelper.Do(exechelper.Spec{
 			Context: ctx,
@@ -451,9 +786,11 @@ func (e Environment) Apply(ctx context.Context, dryRunArg, envDir string, charts
 			Stderr:  os.Stdout,
 		})
 		if err != nil {
+			cleanup()
 			return fmt.Errorf("failed to execute kubectl apply command: %w", err)
 		}
 		_, err = proc.Wait()
+		cleanup()
 		if err != nil {
 			if s.Present {
 				// failed to apply (error!)
@@ -487,6 +824,44 @@ type DeploymentSpec struct {
 
 	// ExcludeChartCRDs to apply crds dir in chart
 	ExcludeChartCRDs bool `json:"excludeChartCRDs" yaml:"excludeChartCRDs"`
+
+	// Hooks renders helm hooks into separate files, and run them when applying
+	Hooks bool `json:"hooks" yaml:"hooks"`
+
+	// FreeFormValues are values keys (name or full key path) allowed to contain keys
+	// not defined in chart default values
+	FreeFormValues []string `json:"freeFormValues" yaml:"freeFormValues"`
+
+	// ReleaseName of the helm release, defaults to the name part of Name
+	ReleaseName string `json:"releaseName" yaml:"releaseName"`
+
+	// NameOverrideMode controls which value is set to the name part of Name, one of
+	// `fullnameOverride` (default), `nameOverride` and `none`
+	NameOverrideMode string `json:"nameOverrideMode" yaml:"nameOverrideMode"`
+
+	// Patches applied to rendered manifests in order
+	Patches []PatchSpec `json:"patches" yaml:"patches"`
+
+	// Kustomize is the dir of kustomization applied to rendered manifests after patches,
+	// relative to the environment values dir, rendered manifests are provided as
+	// `helm-output.yaml` in the dir
+	Kustomize string `json:"kustomize" yaml:"kustomize"`
+
+	// Set values on top of merged values like `helm --set`, keys are in the same format
+	// (e.g. `image.tag`, `args[0]`)
+	Set map[string]interface{} `json:"set" yaml:"set"`
+
+	// SetString values always set as string like `helm --set-string`
+	SetString map[string]interface{} `json:"setString" yaml:"setString"`
+
+	// SetFile values set to file content like `helm --set-file`, path relative to the
+	// environment values dir
+	SetFile map[string]string `json:"setFile" yaml:"setFile"`
+
+	// CommonLabels and CommonAnnotations are added to all rendered resources of this deployment,
+	// override environment level ones with the same key
+	CommonLabels      map[string]string `json:"commonLabels" yaml:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations" yaml:"commonAnnotations"`
 }
 
 func (c DeploymentSpec) Filename(subChart string) string {
@@ -505,6 +880,11 @@ func (c DeploymentSpec) Filename(subChart string) string {
 	return fmt.Sprintf("%s.%s[%s.%s@%s].yaml", namespace, name, repoName, chartName+subChart, chartVersion)
 }
 
+// ValuesTemplateFilename is the name of values file rendered as go template before use
+func (c DeploymentSpec) ValuesTemplateFilename(subChart string) string {
+	return c.Filename(subChart) + constant.ValuesTemplateFileExt
+}
+
 func (c DeploymentSpec) NamespaceAndName() (namespace, name string) {
 	parts := strings.SplitN(c.Name, "/", 2)
 	if len(parts) != 2 {
@@ -526,9 +906,52 @@ func (c DeploymentSpec) Validate(charts map[string]*ChartSpec) error {
 		err = multierr.Append(err, fmt.Errorf("deployment chart %q not listed", c.Chart))
 	}
 
+	for i, p := range c.Patches {
+		if pErr := p.Validate(); pErr != nil {
+			err = multierr.Append(err, fmt.Errorf("invalid patches[%d]: %w", i, pErr))
+		}
+	}
+
+	switch c.NameOverrideMode {
+	case "", NameOverrideFullname, NameOverrideName, NameOverrideNone:
+	default:
+		err = multierr.Append(err, fmt.Errorf("invalid nameOverrideMode %q, expecting one of %q, %q, %q",
+			c.NameOverrideMode, NameOverrideFullname, NameOverrideName, NameOverrideNone))
+	}
+
 	return multierr.Append(err, c.GetState().Validate())
 }
 
+const (
+	NameOverrideFullname = "fullnameOverride"
+	NameOverrideName     = "nameOverride"
+	NameOverrideNone     = "none"
+)
+
+// GetReleaseName returns the helm release name of the deployment
+func (c DeploymentSpec) GetReleaseName() string {
+	if c.ReleaseName != "" {
+		return c.ReleaseName
+	}
+
+	_, name := c.NamespaceAndName()
+	return name
+}
+
+// NameOverrideValues returns values (in `<key>=<value>` format) to override chart name with
+// the deployment name
+func (c DeploymentSpec) NameOverrideValues() []string {
+	_, name := c.NamespaceAndName()
+	switch c.NameOverrideMode {
+	case NameOverrideNone:
+		return nil
+	case NameOverrideName:
+		return []string{NameOverrideName + "=" + name}
+	default:
+		return []string{NameOverrideFullname + "=" + name}
+	}
+}
+
 func (c DeploymentSpec) GetState() DeploymentState {
 	ret := new(DeploymentState)
 	ret.Present = true
@@ -540,7 +963,7 @@ func (c DeploymentSpec) GetState() DeploymentState {
 			ret.Present = true
 		case "absent":
 			ret.Present = false
-		case "crds":
+		case "crds", "keepcrds":
 			ret.CRDPresent = true
 		case "nocrds":
 			ret.CRDPresent = false
@@ -559,7 +982,10 @@ func (c DeploymentSpec) GetState() DeploymentState {
 }
 
 type DeploymentState struct {
-	Present    bool
+	Present bool
+
+	// CRDPresent means custom resource definitions of the deployment are applied when present,
+	// and kept in cluster when absent (deleting crds will delete all custom resources)
 	CRDPresent bool
 
 	// set --validate=false to kubectl
@@ -576,3 +1002,75 @@ func (s DeploymentState) Validate() error {
 
 	return err
 }
+
+// customManifests returns manifest files in custom manifests dir of the deployment, when
+// there is a kustomization in the dir, it's built into a temporary file
+//
+// cleanup MUST be called once files are used
+func (e Environment) customManifests(envDir string, d *DeploymentSpec) (files []string, cleanup func(), err error) {
+	cDir := e.CustomManifestsDir(envDir, d)
+	cleanup = func() {}
+
+	if !hasKustomization(cDir) {
+		files, err = customManifestFiles(cDir, e.PatchFiles(envDir, d))
+		return files, cleanup, err
+	}
+
+	manifests, err := kustomizeBuild(cDir, nil)
+	if err != nil {
+		return nil, cleanup, err
+	}
+
+	f, err := ioutil.TempFile(os.TempDir(), "helm-stack-kustomize-*.yaml")
+	if err != nil {
+		return nil, cleanup, fmt.Errorf("failed to create temporary manifests file: %w", err)
+	}
+
+	cleanup = func() { _ = os.Remove(f.Name()) }
+
+	_, err = f.Write(manifests)
+	_ = f.Close()
+	if err != nil {
+		cleanup()
+		return nil, func() {}, fmt.Errorf("failed to write kustomize output: %w", err)
+	}
+
+	return []string{f.Name()}, cleanup, nil
+}
+
+// customManifestFiles finds manifest files in custom manifests dir recursively (as kubectl does)
+// excluding files used for other purposes (e.g. patches)
+func customManifestFiles(dir string, exclude []string) ([]string, error) {
+	excluded := make(map[string]struct{})
+	for _, f := range exclude {
+		excluded[filepath.Clean(f)] = struct{}{}
+	}
+
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		if _, ok := excluded[filepath.Clean(path)]; ok {
+			return nil
+		}
+
+		switch filepath.Ext(path) {
+		case ".json", ".yaml", ".yml":
+			files = append(files, path)
+		}
+
+		return nil
+	})
+
+	return files, err
+}
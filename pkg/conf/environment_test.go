@@ -0,0 +1,178 @@
+package conf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/multierr"
+)
+
+func TestEnvironment_Validate(t *testing.T) {
+	charts := map[string]*ChartSpec{"foo@latest": {Name: "foo@latest"}}
+
+	assert.NoError(t, Environment{
+		Name:            "test",
+		ManifestsLayout: ManifestsLayoutSplit,
+		Deployments:     []DeploymentSpec{{Name: "default/foo", Chart: "foo@latest"}},
+	}.Validate(charts))
+
+	err := Environment{
+		ManifestsLayout: "flat",
+		Deployments:     []DeploymentSpec{{Name: "default/foo", Chart: "bar@latest"}},
+		ImageRewrites:   []ImageRewriteSpec{{Prefix: "docker.io/"}, {}},
+	}.Validate(charts)
+
+	// all errors reported
+	assert.Len(t, multierr.Errors(err), 4)
+	assert.Contains(t, err.Error(), "invalid empty deployment environment name")
+	assert.Contains(t, err.Error(), `deployment chart "bar@latest" not listed`)
+	assert.Contains(t, err.Error(), `invalid manifestsLayout "flat"`)
+	assert.Contains(t, err.Error(), "invalid imageRewrites[1]")
+}
+
+func TestDeploymentSpec_NameOverrideMode(t *testing.T) {
+	charts := map[string]*ChartSpec{"foo@latest": {Name: "foo@latest"}}
+
+	tests := []struct {
+		name        string
+		d           DeploymentSpec
+		releaseName string
+		values      []string
+		invalid     bool
+	}{
+		{
+			name:        "Default",
+			d:           DeploymentSpec{Name: "default/bar", Chart: "foo@latest"},
+			releaseName: "bar",
+			values:      []string{"fullnameOverride=bar"},
+		},
+		{
+			name: "Fullname",
+			d: DeploymentSpec{
+				Name: "default/bar", Chart: "foo@latest",
+				ReleaseName: "baz", NameOverrideMode: NameOverrideFullname,
+			},
+			releaseName: "baz",
+			values:      []string{"fullnameOverride=bar"},
+		},
+		{
+			name: "Name",
+			d: DeploymentSpec{
+				Name: "default/bar", Chart: "foo@latest",
+				NameOverrideMode: NameOverrideName,
+			},
+			releaseName: "bar",
+			values:      []string{"nameOverride=bar"},
+		},
+		{
+			name: "None",
+			d: DeploymentSpec{
+				Name: "default/bar", Chart: "foo@latest",
+				NameOverrideMode: NameOverrideNone,
+			},
+			releaseName: "bar",
+		},
+		{
+			name: "Invalid",
+			d: DeploymentSpec{
+				Name: "default/bar", Chart: "foo@latest",
+				NameOverrideMode: "name",
+			},
+			releaseName: "bar",
+			values:      []string{"fullnameOverride=bar"},
+			invalid:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.d.Validate(charts)
+			if test.invalid {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, test.releaseName, test.d.GetReleaseName())
+			assert.EqualValues(t, test.values, test.d.NameOverrideValues())
+		})
+	}
+}
+
+func TestReplaceDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-stack-test-*")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	var (
+		src = filepath.Join(dir, ".manifests-staging-1")
+		dst = filepath.Join(dir, "manifests")
+	)
+
+	backups := func() []string {
+		files, err := ioutil.ReadDir(dir)
+		assert.NoError(t, err)
+
+		var ret []string
+		for _, f := range files {
+			if strings.HasPrefix(f.Name(), ManifestsBackupDirPrefix) {
+				ret = append(ret, f.Name())
+			}
+		}
+		return ret
+	}
+
+	readFile := func(file string) string {
+		data, err := ioutil.ReadFile(file)
+		assert.NoError(t, err)
+		return string(data)
+	}
+
+	t.Run("No Dst", func(t *testing.T) {
+		assert.True(t, writeTestFiles(t, src, map[string]string{"a.yaml": "a"}))
+		assert.NoError(t, replaceDir(src, dst, ManifestsBackupDirPrefix))
+
+		assert.Equal(t, "a", readFile(filepath.Join(dst, "a.yaml")))
+		_, err := os.Stat(src)
+		assert.True(t, os.IsNotExist(err))
+		assert.Len(t, backups(), 0)
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		assert.True(t, writeTestFiles(t, src, map[string]string{"b.yaml": "b"}))
+		assert.NoError(t, replaceDir(src, dst, ManifestsBackupDirPrefix))
+
+		assert.Equal(t, "b", readFile(filepath.Join(dst, "b.yaml")))
+		_, err := os.Stat(filepath.Join(dst, "a.yaml"))
+		assert.True(t, os.IsNotExist(err))
+		assert.Len(t, backups(), 0)
+	})
+
+	t.Run("Rename Failed", func(t *testing.T) {
+		// src missing, dst is restored
+		err := replaceDir(filepath.Join(dir, ".manifests-staging-missing"), dst, ManifestsBackupDirPrefix)
+		assert.Error(t, err)
+
+		assert.Equal(t, "b", readFile(filepath.Join(dst, "b.yaml")))
+		assert.Len(t, backups(), 0)
+	})
+
+	t.Run("Interrupted Before", func(t *testing.T) {
+		// dst moved aside but src not renamed by a previous run
+		leftover := filepath.Join(dir, ManifestsBackupDirPrefix+"deadbeef")
+		assert.NoError(t, os.Rename(dst, leftover))
+
+		assert.True(t, writeTestFiles(t, src, map[string]string{"c.yaml": "c"}))
+		assert.NoError(t, replaceDir(src, dst, ManifestsBackupDirPrefix))
+
+		assert.Equal(t, "c", readFile(filepath.Join(dst, "c.yaml")))
+		// left for `helm-stack clean`
+		assert.Equal(t, []string{filepath.Base(leftover)}, backups())
+	})
+}
@@ -41,6 +41,12 @@ type AppConfig struct {
 
 	// LocalChartsDir for charts stored locally
 	LocalChartsDir string `json:"localChartsDir" yaml:"localChartsDir"`
+
+	// Renderer to render charts, one of `sdk` (default) and `exec`
+	Renderer string `json:"renderer" yaml:"renderer"`
+
+	// ManifestsLayout is the default manifests layout of all environments
+	ManifestsLayout string `json:"manifestsLayout" yaml:"manifestsLayout"`
 }
 
 func (c *AppConfig) Override(o *AppConfig) *AppConfig {
@@ -53,6 +59,8 @@ func (c *AppConfig) Override(o *AppConfig) *AppConfig {
 		ChartsDir:       c.ChartsDir,
 		EnvironmentsDir: c.EnvironmentsDir,
 		LocalChartsDir:  c.LocalChartsDir,
+		Renderer:        c.Renderer,
+		ManifestsLayout: c.ManifestsLayout,
 	}
 
 	if o.DebugHelm {
@@ -71,5 +79,13 @@ func (c *AppConfig) Override(o *AppConfig) *AppConfig {
 		result.LocalChartsDir = o.LocalChartsDir
 	}
 
+	if o.Renderer != "" {
+		result.Renderer = o.Renderer
+	}
+
+	if o.ManifestsLayout != "" {
+		result.ManifestsLayout = o.ManifestsLayout
+	}
+
 	return result
 }
@@ -0,0 +1,252 @@
+package conf
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	AnnotationHook             = "helm.sh/hook"
+	AnnotationHookWeight       = "helm.sh/hook-weight"
+	AnnotationHookDeletePolicy = "helm.sh/hook-delete-policy"
+)
+
+// Hook phases of `helm-stack apply`
+const (
+	HookPhasePreApply   = "pre-apply"
+	HookPhasePostApply  = "post-apply"
+	HookPhasePreDelete  = "pre-delete"
+	HookPhasePostDelete = "post-delete"
+)
+
+// Hook delete policies
+const (
+	HookDeletePolicyBeforeCreation = "before-hook-creation"
+	HookDeletePolicySucceeded      = "hook-succeeded"
+	HookDeletePolicyFailed         = "hook-failed"
+)
+
+// HookJobTimeout is the max time to wait for hook jobs to complete
+const HookJobTimeout = 5 * time.Minute
+
+// hookKubectl runs kubectl commands for hooks, replaced in tests
+var hookKubectl = runKubectl
+
+// hookPhases maps helm hook events to apply phases, helm-stack apply doesn't know whether it's
+// an install or upgrade, events not listed (rollback and test) are not supported
+var hookPhases = map[string]string{
+	"pre-install":  HookPhasePreApply,
+	"pre-upgrade":  HookPhasePreApply,
+	"post-install": HookPhasePostApply,
+	"post-upgrade": HookPhasePostApply,
+	"pre-delete":   HookPhasePreDelete,
+	"post-delete":  HookPhasePostDelete,
+}
+
+func isHook(doc *manifestDoc) bool {
+	if doc.obj == nil {
+		return false
+	}
+
+	_, ok := doc.annotations()[AnnotationHook]
+	return ok
+}
+
+// hookPhasesOf returns sorted apply phases of the hook
+func hookPhasesOf(doc *manifestDoc) []string {
+	phases := make(map[string]struct{})
+	for _, event := range strings.Split(doc.annotations()[AnnotationHook], ",") {
+		if phase, ok := hookPhases[strings.TrimSpace(event)]; ok {
+			phases[phase] = struct{}{}
+		}
+	}
+
+	ret := make([]string, 0, len(phases))
+	for p := range phases {
+		ret = append(ret, p)
+	}
+	sort.Strings(ret)
+
+	return ret
+}
+
+func hookWeightOf(doc *manifestDoc) int {
+	// invalid weight is treated as 0 like helm
+	weight, _ := strconv.Atoi(strings.TrimSpace(doc.annotations()[AnnotationHookWeight]))
+	return weight
+}
+
+// hookDeletePoliciesOf returns delete policies of the hook, defaults to before-hook-creation
+// like helm
+func hookDeletePoliciesOf(doc *manifestDoc) map[string]struct{} {
+	ret := make(map[string]struct{})
+	for _, p := range strings.Split(doc.annotations()[AnnotationHookDeletePolicy], ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			ret[p] = struct{}{}
+		}
+	}
+
+	if len(ret) == 0 {
+		ret[HookDeletePolicyBeforeCreation] = struct{}{}
+	}
+
+	return ret
+}
+
+// writeHooks writes hooks to `<dir>/<phase>/<weight>_<kind>-<name>.yaml`, a hook is written
+// to all its phases
+func writeHooks(dir string, hooks []*manifestDoc) error {
+	byPhase := make(map[string][]*manifestDoc)
+	for _, doc := range hooks {
+		for _, phase := range hookPhasesOf(doc) {
+			byPhase[phase] = append(byPhase[phase], doc)
+		}
+	}
+
+	for phase, docs := range byPhase {
+		err := writeManifestsDir(filepath.Join(dir, phase), docs, func(doc *manifestDoc) string {
+			return strconv.Itoa(hookWeightOf(doc)) + "_" + splitManifestFilename(doc)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type hookFile struct {
+	file string
+	doc  *manifestDoc
+}
+
+// runHooks applies hooks of the phase one by one in the order of weight, waits for jobs to
+// complete and deletes hooks according to their delete policies
+func (e Environment) runHooks(ctx context.Context, kubectlCmd []string, dryRunArg, hooksDir, phase string) error {
+	dir := filepath.Join(hooksDir, phase)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return fmt.Errorf("failed to check hooks dir %q: %w", dir, err)
+	}
+
+	var hooks []hookFile
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".yaml" {
+			continue
+		}
+
+		file := filepath.Join(dir, f.Name())
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read hook %q: %w", file, err)
+		}
+
+		docs, err := parseManifests(data)
+		if err != nil {
+			return fmt.Errorf("failed to parse hook %q: %w", file, err)
+		}
+
+		for _, doc := range docs {
+			if doc.obj != nil {
+				hooks = append(hooks, hookFile{file: file, doc: doc})
+				break
+			}
+		}
+	}
+
+	sort.SliceStable(hooks, func(i, j int) bool {
+		wi, wj := hookWeightOf(hooks[i].doc), hookWeightOf(hooks[j].doc)
+		if wi != wj {
+			return wi < wj
+		}
+
+		return hooks[i].doc.name() < hooks[j].doc.name()
+	})
+
+	for _, h := range hooks {
+		err = e.runHook(ctx, kubectlCmd, dryRunArg, h)
+		if err != nil {
+			return fmt.Errorf("failed to run %s hook %q: %w", phase, h.file, err)
+		}
+	}
+
+	return nil
+}
+
+func (e Environment) runHook(ctx context.Context, kubectlCmd []string, dryRunArg string, h hookFile) error {
+	policies := hookDeletePoliciesOf(h.doc)
+	deleteHook := func() error {
+		return hookKubectl(ctx, assembleCommandWithoutEmptyString(kubectlCmd,
+			"delete", "--ignore-not-found=true", dryRunArg, "--filename", h.file,
+		))
+	}
+
+	if _, ok := policies[HookDeletePolicyBeforeCreation]; ok {
+		if err := deleteHook(); err != nil {
+			return fmt.Errorf("failed to delete previous hook: %w", err)
+		}
+	}
+
+	err := hookKubectl(ctx, assembleCommandWithoutEmptyString(kubectlCmd,
+		"apply", dryRunArg, "--filename", h.file,
+	))
+
+	if err == nil && h.doc.group() == "batch" && h.doc.kind() == "Job" && dryRunArg == "" {
+		err = waitHookJob(ctx, kubectlCmd, h.file)
+	}
+
+	policy := HookDeletePolicySucceeded
+	if err != nil {
+		policy = HookDeletePolicyFailed
+	}
+
+	if _, ok := policies[policy]; ok {
+		if dErr := deleteHook(); dErr != nil && err == nil {
+			return fmt.Errorf("failed to delete succeeded hook: %w", dErr)
+		}
+	}
+
+	return err
+}
+
+// waitHookJob waits for the hook job to complete, returns as soon as the job failed
+func waitHookJob(ctx context.Context, kubectlCmd []string, file string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	waitFor := func(condition string) <-chan error {
+		ret := make(chan error, 1)
+		go func() {
+			ret <- hookKubectl(ctx, assembleCommandWithoutEmptyString(kubectlCmd,
+				"wait", "--for", "condition="+condition, "--timeout", HookJobTimeout.String(),
+				"--filename", file,
+			))
+		}()
+
+		return ret
+	}
+
+	complete, failed := waitFor("complete"), waitFor("failed")
+	select {
+	case err := <-complete:
+		return err
+	case err := <-failed:
+		if err == nil {
+			return fmt.Errorf("job failed")
+		}
+
+		// not failed before timeout
+		return <-complete
+	}
+}
@@ -0,0 +1,220 @@
+package conf
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHookAnnotations(t *testing.T) {
+	const data = `---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  annotations:
+    helm.sh/hook: post-upgrade, pre-install,pre-upgrade,test
+    helm.sh/hook-weight: "-5"
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: gen
+  annotations:
+    helm.sh/hook: pre-delete
+    helm.sh/hook-delete-policy: hook-succeeded,hook-failed
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+`
+
+	docs, err := parseManifests([]byte(data))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.True(t, isHook(docs[0]))
+	assert.Equal(t, []string{HookPhasePostApply, HookPhasePreApply}, hookPhasesOf(docs[0]))
+	assert.Equal(t, -5, hookWeightOf(docs[0]))
+	assert.Equal(t, map[string]struct{}{HookDeletePolicyBeforeCreation: {}}, hookDeletePoliciesOf(docs[0]))
+
+	assert.Equal(t, []string{HookPhasePreDelete}, hookPhasesOf(docs[1]))
+	assert.Equal(t, 0, hookWeightOf(docs[1]))
+	assert.Equal(t, map[string]struct{}{
+		HookDeletePolicySucceeded: {},
+		HookDeletePolicyFailed:    {},
+	}, hookDeletePoliciesOf(docs[1]))
+
+	assert.False(t, isHook(docs[2]))
+}
+
+const testHooks = `---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  annotations:
+    helm.sh/hook: pre-install,post-upgrade
+    helm.sh/hook-weight: "-5"
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: gen
+  annotations:
+    helm.sh/hook: pre-delete
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: b
+  annotations:
+    helm.sh/hook: pre-upgrade
+    helm.sh/hook-weight: "1"
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: a
+  annotations:
+    helm.sh/hook: pre-upgrade
+    helm.sh/hook-weight: "1"
+    helm.sh/hook-delete-policy: hook-succeeded
+`
+
+// stubHookKubectl replaces hookKubectl with fn until the test finished, returned func
+// lists executed commands
+func stubHookKubectl(t *testing.T, fn func(ctx context.Context, cmd []string) error) func() []string {
+	var (
+		mu       sync.Mutex
+		executed []string
+	)
+
+	hookKubectl = func(ctx context.Context, cmd []string) error {
+		mu.Lock()
+		executed = append(executed, strings.Join(cmd, " "))
+		mu.Unlock()
+
+		return fn(ctx, cmd)
+	}
+	t.Cleanup(func() { hookKubectl = runKubectl })
+
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return append([]string{}, executed...)
+	}
+}
+
+func TestWriteHooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-stack-test-*")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	docs, err := parseManifests([]byte(testHooks))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	if !assert.NoError(t, writeHooks(dir, docs)) {
+		return
+	}
+
+	var files []string
+	assert.NoError(t, filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files = append(files, strings.TrimPrefix(path, dir+string(filepath.Separator)))
+		}
+
+		return err
+	}))
+
+	assert.Equal(t, []string{
+		filepath.Join(HookPhasePostApply, "-5_job-migrate.yaml"),
+		filepath.Join(HookPhasePreApply, "-5_job-migrate.yaml"),
+		filepath.Join(HookPhasePreApply, "1_configmap-a.yaml"),
+		filepath.Join(HookPhasePreApply, "1_configmap-b.yaml"),
+		filepath.Join(HookPhasePreDelete, "0_secret-gen.yaml"),
+	}, files)
+}
+
+func TestEnvironment_runHooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-stack-test-*")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	docs, err := parseManifests([]byte(testHooks))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	if !assert.NoError(t, writeHooks(dir, docs)) {
+		return
+	}
+
+	cmds := stubHookKubectl(t, func(ctx context.Context, cmd []string) error {
+		return nil
+	})
+
+	err = Environment{}.runHooks(context.TODO(), []string{"kubectl"}, "--dry-run=client", dir, HookPhasePreApply)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	file := func(name string) string {
+		return filepath.Join(dir, HookPhasePreApply, name)
+	}
+
+	// ordered by weight, then by name
+	assert.Equal(t, []string{
+		"kubectl delete --ignore-not-found=true --dry-run=client --filename " + file("-5_job-migrate.yaml"),
+		"kubectl apply --dry-run=client --filename " + file("-5_job-migrate.yaml"),
+		"kubectl apply --dry-run=client --filename " + file("1_configmap-a.yaml"),
+		"kubectl delete --ignore-not-found=true --dry-run=client --filename " + file("1_configmap-a.yaml"),
+		"kubectl delete --ignore-not-found=true --dry-run=client --filename " + file("1_configmap-b.yaml"),
+		"kubectl apply --dry-run=client --filename " + file("1_configmap-b.yaml"),
+	}, cmds())
+}
+
+func TestWaitHookJob(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		condition string
+		hasErr    bool
+	}{
+		{name: "Complete", condition: "condition=complete"},
+		{name: "Failed", condition: "condition=failed", hasErr: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			stubHookKubectl(t, func(ctx context.Context, cmd []string) error {
+				if strings.Contains(strings.Join(cmd, " "), test.condition) {
+					return nil
+				}
+
+				// the other condition is never met
+				<-ctx.Done()
+				return ctx.Err()
+			})
+
+			err := waitHookJob(context.TODO(), []string{"kubectl"}, "job.yaml")
+			if test.hasErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package conf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ImageRewriteSpec rewrites container images matched by prefix or regex
+type ImageRewriteSpec struct {
+	// Prefix of images to be replaced by Replacement (e.g. `docker.io/` to `registry.example.com/`),
+	// it's matched against both the image and its fully qualified name (e.g. `docker.io/library/nginx`
+	// for `nginx`)
+	Prefix string `json:"prefix" yaml:"prefix"`
+
+	// Regex matching the whole image, replaced by Replacement with `$1` like expansion
+	Regex string `json:"regex" yaml:"regex"`
+
+	Replacement string `json:"replacement" yaml:"replacement"`
+}
+
+func (s ImageRewriteSpec) Validate() error {
+	if (s.Prefix == "") == (s.Regex == "") {
+		return fmt.Errorf("exactly one of prefix and regex must be set")
+	}
+
+	if s.Regex != "" {
+		if _, err := regexp.Compile(s.Regex); err != nil {
+			return fmt.Errorf("invalid regex %q: %w", s.Regex, err)
+		}
+	}
+
+	return nil
+}
+
+// ImageDigestsFilePath returns the path of the environment image digests file, empty if not set
+func (e Environment) ImageDigestsFilePath(envDir string) string {
+	if e.ImageDigestsFile == "" {
+		return ""
+	}
+
+	if filepath.IsAbs(e.ImageDigestsFile) {
+		return e.ImageDigestsFile
+	}
+
+	return filepath.Join(e.ValuesDir(envDir), e.ImageDigestsFile)
+}
+
+type imageRewriter struct {
+	rules   []ImageRewriteSpec
+	regexes []*regexp.Regexp
+	digests map[string]string
+}
+
+// imageRewriter creates a rewriter with environment image rewrite rules and image digests,
+// returns nil if nothing to rewrite
+func (e Environment) imageRewriter(envDir string) (*imageRewriter, error) {
+	if len(e.ImageRewrites) == 0 && e.ImageDigestsFile == "" {
+		return nil, nil
+	}
+
+	r := &imageRewriter{
+		rules:   e.ImageRewrites,
+		regexes: make([]*regexp.Regexp, len(e.ImageRewrites)),
+	}
+
+	for i, rule := range e.ImageRewrites {
+		if rule.Regex == "" {
+			continue
+		}
+
+		var err error
+		r.regexes[i], err = regexp.Compile("^(?:" + rule.Regex + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid image rewrite regex %q: %w", rule.Regex, err)
+		}
+	}
+
+	if file := e.ImageDigestsFilePath(envDir); file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image digests file %q: %w", file, err)
+		}
+
+		err = yaml.Unmarshal(data, &r.digests)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse image digests file %q: %w", file, err)
+		}
+	}
+
+	return r, nil
+}
+
+// Rewrite image with the first matched rule, then pin the image to its digest if defined
+func (r *imageRewriter) Rewrite(image string) string {
+	ret := image
+	for i, rule := range r.rules {
+		if re := r.regexes[i]; re != nil {
+			if re.MatchString(image) {
+				ret = re.ReplaceAllString(image, rule.Replacement)
+				break
+			}
+
+			continue
+		}
+
+		if strings.HasPrefix(image, rule.Prefix) {
+			ret = rule.Replacement + strings.TrimPrefix(image, rule.Prefix)
+			break
+		}
+
+		if fullName := fullImageName(image); strings.HasPrefix(fullName, rule.Prefix) {
+			ret = rule.Replacement + strings.TrimPrefix(fullName, rule.Prefix)
+			break
+		}
+	}
+
+	if strings.Contains(ret, "@") {
+		// already pinned
+		return ret
+	}
+
+	if digest, ok := r.digests[ret]; ok && digest != "" {
+		return ret + "@" + digest
+	}
+
+	return ret
+}
+
+// fullImageName returns fully qualified image name with registry and `library/` for docker hub
+// official images (e.g. `docker.io/library/nginx:1.19` for `nginx:1.19`)
+func fullImageName(image string) string {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return image
+	}
+
+	if len(parts) == 1 {
+		return "docker.io/library/" + image
+	}
+
+	return "docker.io/" + image
+}
+
+// rewriteImages rewrites container images in all manifest documents
+func rewriteImages(docs []*manifestDoc, r *imageRewriter) {
+	for _, doc := range docs {
+		if visitImages(doc, r.Rewrite) {
+			doc.modified = true
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package conf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImageRewriter(t *testing.T) {
+	e := Environment{
+		ImageRewrites: []ImageRewriteSpec{
+			{Regex: `quay\.io/(.*)`, Replacement: "mirror.local/quay/$1"},
+			{Prefix: "docker.io/", Replacement: "mirror.local/"},
+		},
+	}
+
+	r, err := e.imageRewriter("")
+	if !assert.NoError(t, err) {
+		return
+	}
+	r.digests = map[string]string{"mirror.local/library/nginx:1.19": "sha256:abcd"}
+
+	for image, expected := range map[string]string{
+		"nginx:1.19":                            "mirror.local/library/nginx:1.19@sha256:abcd",
+		"bitnami/redis:6.0":                     "mirror.local/bitnami/redis:6.0",
+		"docker.io/bitnami/redis:6.0":           "mirror.local/bitnami/redis:6.0",
+		"quay.io/prometheus/prometheus:v2.22.1": "mirror.local/quay/prometheus/prometheus:v2.22.1",
+		"gcr.io/foo/bar:v1":                     "gcr.io/foo/bar:v1",
+		"localhost/foo:v1":                      "localhost/foo:v1",
+	} {
+		assert.Equal(t, expected, r.Rewrite(image), image)
+	}
+
+	assert.Error(t, ImageRewriteSpec{Prefix: "a", Regex: "b"}.Validate())
+	assert.Error(t, ImageRewriteSpec{Regex: "("}.Validate())
+	assert.NoError(t, ImageRewriteSpec{Prefix: "docker.io/"}.Validate())
+}
@@ -0,0 +1,170 @@
+package conf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+// podSpecPaths are paths to pod specs in kinds running containers, in `<group>/<kind>` format
+var podSpecPaths = map[string][]string{
+	"/Pod":                   {"spec"},
+	"/ReplicationController": {"spec", "template", "spec"},
+	"/PodTemplate":           {"template", "spec"},
+
+	"apps/Deployment":       {"spec", "template", "spec"},
+	"apps/StatefulSet":      {"spec", "template", "spec"},
+	"apps/DaemonSet":        {"spec", "template", "spec"},
+	"apps/ReplicaSet":       {"spec", "template", "spec"},
+	"extensions/Deployment": {"spec", "template", "spec"},
+	"extensions/DaemonSet":  {"spec", "template", "spec"},
+	"extensions/ReplicaSet": {"spec", "template", "spec"},
+	"batch/Job":             {"spec", "template", "spec"},
+	"batch/CronJob":         {"spec", "jobTemplate", "spec", "template", "spec"},
+
+	// well-known custom resources
+	"argoproj.io/Rollout":                {"spec", "template", "spec"},
+	"monitoring.coreos.com/Prometheus":   {"spec"},
+	"monitoring.coreos.com/Alertmanager": {"spec"},
+	"monitoring.coreos.com/ThanosRuler":  {"spec"},
+}
+
+// imagePaths are paths to image fields outside of containers in well-known custom resources
+var imagePaths = map[string][][]string{
+	"monitoring.coreos.com/Prometheus":   {{"spec", "image"}, {"spec", "thanos", "image"}},
+	"monitoring.coreos.com/Alertmanager": {{"spec", "image"}},
+	"monitoring.coreos.com/ThanosRuler":  {{"spec", "image"}},
+}
+
+// imagesOf returns container images used by the object
+func imagesOf(doc *manifestDoc) []string {
+	var ret []string
+	visitImages(doc, func(image string) string {
+		ret = append(ret, image)
+		return image
+	})
+
+	return ret
+}
+
+// visitImages calls visit with every container image in the object, and sets the image to
+// the returned value, returns true if any image changed
+func visitImages(doc *manifestDoc, visit func(image string) string) bool {
+	if doc.obj == nil {
+		return false
+	}
+
+	changed := false
+	update := func(m map[string]interface{}, key string) {
+		image, _ := m[key].(string)
+		if image == "" {
+			return
+		}
+
+		if newImage := visit(image); newImage != image {
+			m[key] = newImage
+			changed = true
+		}
+	}
+
+	key := doc.group() + "/" + doc.kind()
+	if path, ok := podSpecPaths[key]; ok {
+		podSpec, _ := lookupPath(doc.obj, path).(map[string]interface{})
+		for _, field := range []string{"initContainers", "containers", "ephemeralContainers"} {
+			containers, _ := podSpec[field].([]interface{})
+			for _, c := range containers {
+				if container, ok := c.(map[string]interface{}); ok {
+					update(container, "image")
+				}
+			}
+		}
+	}
+
+	for _, path := range imagePaths[key] {
+		if parent, ok := lookupPath(doc.obj, path[:len(path)-1]).(map[string]interface{}); ok {
+			update(parent, path[len(path)-1])
+		}
+	}
+
+	return changed
+}
+
+func lookupPath(obj map[string]interface{}, path []string) interface{} {
+	var current interface{} = obj
+	for _, p := range path {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+
+		current = m[p]
+	}
+
+	return current
+}
+
+// Images returns container images in generated manifests and custom manifests of deployments
+// present in this environment, keyed by image, values are sorted names of deployments using
+// the image
+func (e Environment) Images(envDir string) (map[string][]string, error) {
+	ret := make(map[string][]string)
+	for i, d := range e.Deployments {
+		if !d.GetState().Present {
+			continue
+		}
+
+		images, err := e.deploymentImages(envDir, &e.Deployments[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to check images of deployment %q: %w", d.Name, err)
+		}
+
+		for image := range images {
+			ret[image] = append(ret[image], d.Name)
+		}
+	}
+
+	for image := range ret {
+		sort.Strings(ret[image])
+	}
+
+	return ret, nil
+}
+
+func (e Environment) deploymentImages(envDir string, d *DeploymentSpec) (map[string]struct{}, error) {
+	var files []string
+	for _, path := range []string{e.ManifestsPath(envDir, d), e.HooksPath(envDir, d)} {
+		f, err := customManifestFiles(path, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check generated manifests: %w", err)
+		}
+
+		files = append(files, f...)
+	}
+
+	customFiles, cleanup, err := e.customManifests(envDir, d)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check custom manifests: %w", err)
+	}
+	defer cleanup()
+
+	ret := make(map[string]struct{})
+	for _, f := range append(files, customFiles...) {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read manifests %q: %w", f, err)
+		}
+
+		docs, err := parseManifests(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse manifests %q: %w", f, err)
+		}
+
+		for _, doc := range docs {
+			for _, image := range imagesOf(doc) {
+				ret[image] = struct{}{}
+			}
+		}
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,52 @@
+package conf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImagesOf(t *testing.T) {
+	const data = `---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: foo
+spec:
+  initContainers:
+  - name: init
+    image: busybox
+  containers:
+  - name: foo
+    image: foo:v1
+  ephemeralContainers:
+  - name: debug
+    image: debug:v1
+---
+apiVersion: monitoring.coreos.com/v1
+kind: Prometheus
+metadata:
+  name: foo
+spec:
+  image: quay.io/prometheus/prometheus:v2.22.1
+  containers:
+  - name: sidecar
+    image: sidecar:v1
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+data:
+  image: foo:v1
+`
+
+	docs, err := parseManifests([]byte(data))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, []string{"busybox", "foo:v1", "debug:v1"}, imagesOf(docs[0]))
+	assert.Equal(t, []string{"sidecar:v1", "quay.io/prometheus/prometheus:v2.22.1"}, imagesOf(docs[1]))
+	assert.Empty(t, imagesOf(docs[2]))
+}
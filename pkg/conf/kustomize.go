@@ -0,0 +1,124 @@
+package conf
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"k8s.io/cli-runtime/pkg/kustomize"
+	"sigs.k8s.io/kustomize/pkg/constants"
+	"sigs.k8s.io/kustomize/pkg/fs"
+)
+
+// KustomizeHelmOutputFile is the file name of rendered manifests provided to the post render
+// kustomization, reference it in `resources` of the kustomization
+const KustomizeHelmOutputFile = "helm-output.yaml"
+
+// hasKustomization checks whether there is a kustomization file in dir
+func hasKustomization(dir string) bool {
+	for _, f := range constants.KustomizationFileNames {
+		info, err := os.Stat(filepath.Join(dir, f))
+		if err == nil && !info.IsDir() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// kustomizeBuild runs `kustomize build` for dir, files (path -> content) are provided
+// in addition to files on disk
+func kustomizeBuild(dir string, files map[string][]byte) ([]byte, error) {
+	fSys, err := newKustomizeFS(files)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	err = kustomize.RunKustomizeBuild(buf, fSys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build kustomization %q: %w", dir, err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// kustomizeFS is the real file system with in memory files
+type kustomizeFS struct {
+	fs.FileSystem
+
+	// absolute path with symlinks resolved -> content
+	files map[string][]byte
+}
+
+func newKustomizeFS(files map[string][]byte) (*kustomizeFS, error) {
+	ret := &kustomizeFS{
+		FileSystem: fs.MakeRealFS(),
+		files:      make(map[string][]byte),
+	}
+
+	for path, data := range files {
+		dir, err := filepath.Abs(filepath.Dir(path))
+		if err != nil {
+			return nil, err
+		}
+
+		dir, err = filepath.EvalSymlinks(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve dir of %q: %w", path, err)
+		}
+
+		ret.files[filepath.Join(dir, filepath.Base(path))] = data
+	}
+
+	return ret, nil
+}
+
+func (f *kustomizeFS) lookup(path string) (string, bool) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+
+	dir, err := filepath.EvalSymlinks(filepath.Dir(abs))
+	if err != nil {
+		return "", false
+	}
+
+	abs = filepath.Join(dir, filepath.Base(abs))
+	_, ok := f.files[abs]
+	return abs, ok
+}
+
+func (f *kustomizeFS) CleanedAbs(path string) (fs.ConfirmedDir, string, error) {
+	if abs, ok := f.lookup(path); ok {
+		return fs.ConfirmedDir(filepath.Dir(abs)), filepath.Base(abs), nil
+	}
+
+	return f.FileSystem.CleanedAbs(path)
+}
+
+func (f *kustomizeFS) Exists(name string) bool {
+	if _, ok := f.lookup(name); ok {
+		return true
+	}
+
+	return f.FileSystem.Exists(name)
+}
+
+func (f *kustomizeFS) IsDir(name string) bool {
+	if _, ok := f.lookup(name); ok {
+		return false
+	}
+
+	return f.FileSystem.IsDir(name)
+}
+
+func (f *kustomizeFS) ReadFile(name string) ([]byte, error) {
+	if abs, ok := f.lookup(name); ok {
+		return f.files[abs], nil
+	}
+
+	return f.FileSystem.ReadFile(name)
+}
@@ -0,0 +1,128 @@
+package conf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvironment_postRender_Kustomize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-stack-test-*")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	e := Environment{Name: "test"}
+	d := DeploymentSpec{Name: "default/foo", Kustomize: "kustomize"}
+
+	if !writeTestFiles(t, e.ValuesDir(dir), map[string]string{
+		"kustomize/kustomization.yaml": `
+resources:
+- helm-output.yaml
+- extra.yaml
+commonLabels:
+  team: a
+`,
+		"kustomize/extra.yaml": `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: extra
+`,
+	}) {
+		return
+	}
+
+	result, err := e.postRender(dir, d, []byte(`---
+# Source: foo/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+data:
+  foo: bar
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, `apiVersion: v1
+data:
+  foo: bar
+kind: ConfigMap
+metadata:
+  labels:
+    team: a
+  name: foo
+  namespace: default
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  labels:
+    team: a
+  name: extra
+`, string(result))
+
+	// rendered manifests are only provided in memory
+	_, err = os.Stat(filepath.Join(e.ValuesDir(dir), "kustomize", KustomizeHelmOutputFile))
+	assert.True(t, os.IsNotExist(err))
+
+	d.Kustomize = "missing"
+	_, err = e.postRender(dir, d, []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: foo\n"))
+	assert.Error(t, err)
+}
+
+func TestEnvironment_customManifests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-stack-test-*")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	e := Environment{Name: "test"}
+	d := DeploymentSpec{Name: "default/foo"}
+	cDir := e.CustomManifestsDir(dir, &d)
+
+	if !writeTestFiles(t, cDir, map[string]string{
+		"a.yaml":     "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n",
+		"sub/b.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: b\n",
+	}) {
+		return
+	}
+
+	t.Run("Files", func(t *testing.T) {
+		files, cleanup, err := e.customManifests(dir, &d)
+		defer cleanup()
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{filepath.Join(cDir, "a.yaml"), filepath.Join(cDir, "sub", "b.yaml")}, files)
+	})
+
+	t.Run("Kustomization", func(t *testing.T) {
+		if !writeTestFiles(t, cDir, map[string]string{
+			"kustomization.yaml": "resources:\n- a.yaml\nnamespace: bar\n",
+		}) {
+			return
+		}
+
+		files, cleanup, err := e.customManifests(dir, &d)
+		if !assert.NoError(t, err) || !assert.Len(t, files, 1) {
+			cleanup()
+			return
+		}
+
+		data, err := ioutil.ReadFile(files[0])
+		assert.NoError(t, err)
+		// only resources in the kustomization
+		assert.Equal(t, "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n  namespace: bar\n", string(data))
+
+		cleanup()
+		_, err = os.Stat(files[0])
+		assert.True(t, os.IsNotExist(err))
+	})
+}
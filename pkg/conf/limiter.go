@@ -0,0 +1,36 @@
+package conf
+
+import (
+	"context"
+)
+
+// JobLimiter limits the number of concurrent jobs, can be shared by multiple environments
+type JobLimiter chan struct{}
+
+// NewJobLimiter creates a limiter allowing n jobs running at the same time (at least 1)
+func NewJobLimiter(n int) JobLimiter {
+	if n < 1 {
+		n = 1
+	}
+
+	return make(JobLimiter, n)
+}
+
+// Acquire waits for a free job slot, returns false if ctx is canceled
+func (l JobLimiter) Acquire(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	select {
+	case l <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Release the job slot acquired
+func (l JobLimiter) Release() {
+	<-l
+}
@@ -0,0 +1,100 @@
+package conf
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobLimiter(t *testing.T) {
+	t.Run("At Least One", func(t *testing.T) {
+		assert.Equal(t, 1, cap(NewJobLimiter(0)))
+		assert.Equal(t, 1, cap(NewJobLimiter(-1)))
+		assert.Equal(t, 4, cap(NewJobLimiter(4)))
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		l := NewJobLimiter(1)
+
+		ctx, cancel := context.WithCancel(context.TODO())
+		cancel()
+
+		// never acquires with cancelled ctx even when there is a free slot
+		for i := 0; i < 10; i++ {
+			assert.False(t, l.Acquire(ctx))
+		}
+		assert.Len(t, l, 0)
+	})
+
+	t.Run("Cancelled While Waiting", func(t *testing.T) {
+		l := NewJobLimiter(1)
+		if !assert.True(t, l.Acquire(context.TODO())) {
+			return
+		}
+
+		ctx, cancel := context.WithCancel(context.TODO())
+		defer cancel()
+
+		result := make(chan bool)
+		go func() {
+			result <- l.Acquire(ctx)
+		}()
+
+		select {
+		case <-result:
+			assert.Fail(t, "acquired without free slot")
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		cancel()
+		assert.False(t, <-result)
+
+		// the slot is still held
+		assert.Len(t, l, 1)
+		l.Release()
+		assert.True(t, l.Acquire(context.TODO()))
+		l.Release()
+	})
+
+	t.Run("Concurrency", func(t *testing.T) {
+		const n = 3
+		l := NewJobLimiter(n)
+
+		var (
+			running, maxRunning int32
+			wg                  sync.WaitGroup
+		)
+
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				if !l.Acquire(context.TODO()) {
+					return
+				}
+				defer l.Release()
+
+				current := atomic.AddInt32(&running, 1)
+				for {
+					max := atomic.LoadInt32(&maxRunning)
+					if current <= max || atomic.CompareAndSwapInt32(&maxRunning, max, current) {
+						break
+					}
+				}
+
+				time.Sleep(5 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+			}()
+		}
+
+		wg.Wait()
+		assert.LessOrEqual(t, int(maxRunning), n)
+		assert.Len(t, l, 0)
+	})
+}
@@ -0,0 +1,417 @@
+package conf
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+const manifestSourcePrefix = "# Source: "
+
+// manifestDoc is a yaml document in rendered manifests, its raw content is kept as is
+// unless the object is modified
+type manifestDoc struct {
+	// raw content including the leading `---` line (if any)
+	raw []byte
+
+	// source template path from the `# Source: ` comment
+	source string
+
+	// obj is the parsed kubernetes object, nil if the document is empty
+	obj map[string]interface{}
+
+	modified bool
+}
+
+// parseManifests splits manifests into yaml documents
+func parseManifests(data []byte) ([]*manifestDoc, error) {
+	var (
+		docs    []*manifestDoc
+		current []byte
+	)
+
+	addDoc := func() error {
+		if len(current) == 0 {
+			return nil
+		}
+
+		doc := &manifestDoc{raw: current}
+		current = nil
+
+		for _, line := range strings.Split(string(doc.raw), "\n") {
+			if strings.HasPrefix(line, manifestSourcePrefix) {
+				doc.source = strings.TrimSpace(strings.TrimPrefix(line, manifestSourcePrefix))
+				break
+			}
+		}
+
+		obj := make(map[string]interface{})
+		err := yaml.Unmarshal(doc.raw, &obj)
+		if err != nil {
+			return fmt.Errorf("failed to parse manifest %q: %w", doc.source, err)
+		}
+
+		if len(obj) != 0 {
+			doc.obj = obj
+		}
+
+		docs = append(docs, doc)
+		return nil
+	}
+
+	for len(data) != 0 {
+		var line []byte
+		idx := bytes.IndexByte(data, '\n')
+		if idx < 0 {
+			line, data = data, nil
+		} else {
+			line, data = data[:idx+1], data[idx+1:]
+		}
+
+		if isManifestSeparator(line) {
+			if err := addDoc(); err != nil {
+				return nil, err
+			}
+		}
+
+		current = append(current, line...)
+	}
+
+	if err := addDoc(); err != nil {
+		return nil, err
+	}
+
+	return docs, nil
+}
+
+func isManifestSeparator(line []byte) bool {
+	return strings.TrimRight(string(line), " \t\r\n") == "---"
+}
+
+// encodeManifests joins documents, unmodified documents are written as is, with a separator
+// added when missing (e.g. the first document re-ordered by normalization)
+func encodeManifests(docs []*manifestDoc) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	for _, doc := range docs {
+		if !doc.modified {
+			if buf.Len() != 0 {
+				endLine(buf)
+
+				firstLine := doc.raw
+				if idx := bytes.IndexByte(firstLine, '\n'); idx >= 0 {
+					firstLine = firstLine[:idx]
+				}
+
+				if !isManifestSeparator(firstLine) {
+					buf.WriteString("---\n")
+				}
+			}
+
+			buf.Write(doc.raw)
+			continue
+		}
+
+		if doc.obj == nil {
+			// removed
+			continue
+		}
+
+		data, err := yaml.Marshal(doc.obj)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode manifest %q: %w", doc.source, err)
+		}
+
+		endLine(buf)
+		buf.WriteString("---\n")
+		if doc.source != "" {
+			buf.WriteString(manifestSourcePrefix + doc.source + "\n")
+		}
+		buf.Write(data)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// endLine terminates the last line in buf if not empty
+func endLine(buf *bytes.Buffer) {
+	if buf.Len() != 0 && buf.Bytes()[buf.Len()-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+}
+
+func (d *manifestDoc) apiVersion() string {
+	v, _ := d.obj["apiVersion"].(string)
+	return v
+}
+
+// group is the api group of the object, empty for core group
+func (d *manifestDoc) group() string {
+	v := d.apiVersion()
+	if idx := strings.LastIndexByte(v, '/'); idx >= 0 {
+		return v[:idx]
+	}
+
+	return ""
+}
+
+func (d *manifestDoc) kind() string {
+	v, _ := d.obj["kind"].(string)
+	return v
+}
+
+func (d *manifestDoc) metadata() map[string]interface{} {
+	v, _ := d.obj["metadata"].(map[string]interface{})
+	return v
+}
+
+func (d *manifestDoc) name() string {
+	v, _ := d.metadata()["name"].(string)
+	return v
+}
+
+func (d *manifestDoc) namespace() string {
+	v, _ := d.metadata()["namespace"].(string)
+	return v
+}
+
+func (d *manifestDoc) labels() map[string]string {
+	m, _ := d.metadata()["labels"].(map[string]interface{})
+	ret := make(map[string]string, len(m))
+	for k, v := range m {
+		ret[k] = toString(v)
+	}
+
+	return ret
+}
+
+func (d *manifestDoc) annotations() map[string]string {
+	m, _ := d.metadata()["annotations"].(map[string]interface{})
+	ret := make(map[string]string, len(m))
+	for k, v := range m {
+		ret[k] = toString(v)
+	}
+
+	return ret
+}
+
+func isCRD(doc *manifestDoc) bool {
+	return doc.obj != nil && doc.group() == "apiextensions.k8s.io" && doc.kind() == "CustomResourceDefinition"
+}
+
+// setMetadataKeys sets key values in `metadata` (field is empty) or in a map field of
+// `metadata` (e.g. `labels`), returns true if anything changed
+//
+// new keys are inserted into the raw content to keep the original document text, the
+// document is marked as modified only when the raw content can not be edited in place
+// (e.g. flow style metadata or existing keys with different values)
+func (d *manifestDoc) setMetadataKeys(field string, kv map[string]string) bool {
+	if d.obj == nil || len(kv) == 0 {
+		return false
+	}
+
+	metadata := d.metadata()
+	if metadata == nil {
+		metadata = make(map[string]interface{})
+		d.obj["metadata"] = metadata
+	}
+
+	target := metadata
+	_, fieldDefined := metadata[field]
+	if field != "" {
+		m, ok := metadata[field].(map[string]interface{})
+		if !ok {
+			m = make(map[string]interface{})
+			metadata[field] = m
+		}
+		target = m
+	}
+
+	var (
+		keys     []string
+		conflict bool
+	)
+	for k, v := range kv {
+		current, defined := target[k]
+		if s, ok := current.(string); ok && s == v {
+			continue
+		}
+
+		conflict = conflict || defined
+		target[k] = v
+		keys = append(keys, k)
+	}
+
+	if len(keys) == 0 {
+		return false
+	}
+
+	if d.modified {
+		return true
+	}
+
+	sort.Strings(keys)
+	lines := make([]string, len(keys))
+	for i, k := range keys {
+		data, err := yaml.Marshal(map[string]string{k: kv[k]})
+		if err != nil {
+			d.modified = true
+			return true
+		}
+
+		lines[i] = strings.TrimRight(string(data), "\n")
+	}
+
+	raw, ok := insertMetadataLines(string(d.raw), field, fieldDefined, lines)
+	if conflict || !ok {
+		d.modified = true
+		return true
+	}
+
+	d.raw = []byte(raw)
+	return true
+}
+
+// insertMetadataLines inserts lines into block style `metadata` (field is empty) or
+// its map field in the raw document
+func insertMetadataLines(raw, field string, fieldDefined bool, lines []string) (string, bool) {
+	docLines := strings.SplitAfter(raw, "\n")
+	if docLines[len(docLines)-1] == "" {
+		docLines = docLines[:len(docLines)-1]
+	}
+
+	start := -1
+	for i, line := range docLines {
+		if isYamlKeyLine(line, 0, "metadata") {
+			start = i
+			break
+		}
+	}
+
+	if start < 0 {
+		return "", false
+	}
+
+	indent, end := yamlBlock(docLines, start, 0)
+	if indent <= 0 {
+		return "", false
+	}
+
+	insertAt := start + 1
+	if field == "" {
+		for i := start + 1; i <= end; i++ {
+			if yamlLineIndent(docLines[i]) == indent && strings.HasPrefix(yamlLineContent(docLines[i]), "name:") {
+				_, nameEnd := yamlBlock(docLines, i, indent)
+				insertAt = nameEnd + 1
+				break
+			}
+		}
+
+		return spliceYamlLines(docLines, insertAt, indent, lines), true
+	}
+
+	fieldLine := -1
+	for i := start + 1; i <= end; i++ {
+		if isYamlKeyLine(docLines[i], indent, field) {
+			fieldLine = i
+			break
+		}
+	}
+
+	if fieldLine < 0 {
+		if fieldDefined {
+			// defined in other style (e.g. `labels: {}`)
+			return "", false
+		}
+
+		lines = append([]string{field + ":"}, indentYamlLines(lines, indent)...)
+		return spliceYamlLines(docLines, end+1, indent, lines), true
+	}
+
+	childIndent, fieldEnd := yamlBlock(docLines, fieldLine, indent)
+	if childIndent <= indent {
+		return "", false
+	}
+
+	return spliceYamlLines(docLines, fieldEnd+1, childIndent, lines), true
+}
+
+// yamlBlock finds indent of child lines and the last line of the block started at
+// line idx with indent
+func yamlBlock(lines []string, idx, indent int) (childIndent, end int) {
+	childIndent, end = -1, idx
+	for i := idx + 1; i < len(lines); i++ {
+		content := yamlLineContent(lines[i])
+		if content == "" || strings.HasPrefix(content, "#") {
+			continue
+		}
+
+		lineIndent := yamlLineIndent(lines[i])
+		if lineIndent <= indent {
+			break
+		}
+
+		if childIndent < 0 {
+			childIndent = lineIndent
+		}
+
+		end = i
+	}
+
+	return childIndent, end
+}
+
+// isYamlKeyLine checks whether the line is a key with block value (e.g. `metadata:`)
+func isYamlKeyLine(line string, indent int, key string) bool {
+	if yamlLineIndent(line) != indent {
+		return false
+	}
+
+	content := yamlLineContent(line)
+	if !strings.HasPrefix(content, key+":") {
+		return false
+	}
+
+	rest := strings.TrimSpace(strings.TrimPrefix(content, key+":"))
+	return rest == "" || strings.HasPrefix(rest, "#")
+}
+
+func yamlLineContent(line string) string {
+	return strings.TrimSpace(line)
+}
+
+func yamlLineIndent(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+func indentYamlLines(lines []string, indent int) []string {
+	ret := make([]string, len(lines))
+	for i, l := range lines {
+		ret[i] = strings.Repeat(" ", indent) + l
+	}
+
+	return ret
+}
+
+func spliceYamlLines(docLines []string, at, indent int, lines []string) string {
+	if at > 0 && !strings.HasSuffix(docLines[at-1], "\n") {
+		docLines[at-1] += "\n"
+	}
+
+	buf := new(strings.Builder)
+	for _, l := range docLines[:at] {
+		buf.WriteString(l)
+	}
+
+	for _, l := range indentYamlLines(lines, indent) {
+		buf.WriteString(l + "\n")
+	}
+
+	for _, l := range docLines[at:] {
+		buf.WriteString(l)
+	}
+
+	return buf.String()
+}
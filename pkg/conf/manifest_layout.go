@@ -0,0 +1,202 @@
+package conf
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ManifestsPath returns path of generated manifests of the deployment, it's a file for `single`
+// manifests layout and a dir for `split` manifests layout
+func (e Environment) ManifestsPath(envDir string, d *DeploymentSpec) string {
+	return e.manifestsPath(e.ManifestsDir(envDir), d)
+}
+
+func (e Environment) manifestsPath(manifestsDir string, d *DeploymentSpec) string {
+	if e.ManifestsLayout == ManifestsLayoutSplit {
+		return filepath.Join(manifestsDir, strings.TrimSuffix(d.Filename(""), ".yaml"))
+	}
+
+	return filepath.Join(manifestsDir, d.Filename(""))
+}
+
+// CRDsPath returns path of generated custom resource definitions of the deployment, it's a file
+// next to the manifests file for `single` manifests layout and the `crds` dir in the manifests
+// dir for `split` manifests layout
+func (e Environment) CRDsPath(envDir string, d *DeploymentSpec) string {
+	return e.crdsPath(e.ManifestsDir(envDir), d)
+}
+
+func (e Environment) crdsPath(manifestsDir string, d *DeploymentSpec) string {
+	if e.ManifestsLayout == ManifestsLayoutSplit {
+		return filepath.Join(e.manifestsPath(manifestsDir, d), "crds")
+	}
+
+	return filepath.Join(manifestsDir, strings.TrimSuffix(d.Filename(""), ".yaml")+".crds.yaml")
+}
+
+// HooksPath returns the dir of generated hooks of the deployment, it's a dir next to the manifests
+// file for `single` manifests layout and the `hooks` dir in the manifests dir for `split` manifests
+// layout
+func (e Environment) HooksPath(envDir string, d *DeploymentSpec) string {
+	return e.hooksPath(e.ManifestsDir(envDir), d)
+}
+
+func (e Environment) hooksPath(manifestsDir string, d *DeploymentSpec) string {
+	if e.ManifestsLayout == ManifestsLayoutSplit {
+		return filepath.Join(e.manifestsPath(manifestsDir, d), "hooks")
+	}
+
+	return filepath.Join(manifestsDir, strings.TrimSuffix(d.Filename(""), ".yaml")+".hooks")
+}
+
+// writeManifests writes manifests of the deployment, custom resource definitions are
+// written separately
+func (e Environment) writeManifests(manifestsDir string, d *DeploymentSpec, manifests []byte) error {
+	docs, err := parseManifests(manifests)
+	if err != nil {
+		return err
+	}
+
+	var crds, hooks, others []*manifestDoc
+	for _, doc := range docs {
+		switch {
+		case isCRD(doc):
+			crds = append(crds, doc)
+		case isHook(doc):
+			hooks = append(hooks, doc)
+		default:
+			others = append(others, doc)
+		}
+	}
+
+	err = writeHooks(e.hooksPath(manifestsDir, d), hooks)
+	if err != nil {
+		return err
+	}
+
+	path := e.manifestsPath(manifestsDir, d)
+	crdsPath := e.crdsPath(manifestsDir, d)
+
+	// charts may contain only crds, kubectl fails to apply empty manifests
+	if hasResources(others) {
+		if e.ManifestsLayout != ManifestsLayoutSplit {
+			err = writeManifestsFile(path, others)
+		} else {
+			err = writeManifestsDir(path, others, splitManifestFilename)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(crds) == 0 {
+		return nil
+	}
+
+	if e.ManifestsLayout != ManifestsLayoutSplit {
+		return writeManifestsFile(crdsPath, crds)
+	}
+
+	return writeManifestsDir(crdsPath, crds, splitManifestFilename)
+}
+
+func hasResources(docs []*manifestDoc) bool {
+	for _, doc := range docs {
+		if doc.obj != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasManifestFiles checks whether path is a file or a dir containing files, sub dirs are not
+// checked as kubectl applies them only with `--recursive`
+func hasManifestFiles(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	if !info.IsDir() {
+		return true
+	}
+
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return false
+	}
+
+	for _, f := range files {
+		if !f.IsDir() {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeManifestsFile(path string, docs []*manifestDoc) error {
+	data, err := encodeManifests(docs)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// writeManifestsDir writes one file per resource to the dir
+func writeManifestsDir(path string, docs []*manifestDoc, filename func(doc *manifestDoc) string) error {
+	err := os.MkdirAll(path, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to ensure manifests dir %q: %w", path, err)
+	}
+
+	used := make(map[string]struct{})
+	for _, doc := range docs {
+		if doc.obj == nil {
+			continue
+		}
+
+		name := filename(doc)
+		for i := 2; ; i++ {
+			if _, ok := used[name]; !ok {
+				break
+			}
+
+			// same kind and name in different groups or namespaces
+			name = strings.TrimSuffix(filename(doc), ".yaml") + "-" + strconv.Itoa(i) + ".yaml"
+		}
+		used[name] = struct{}{}
+
+		err = writeManifestsFile(filepath.Join(path, name), []*manifestDoc{doc})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// splitManifestFilename returns `<kind>-<name>.yaml` in lower case
+func splitManifestFilename(doc *manifestDoc) string {
+	name := strings.ToLower(doc.kind() + "-" + doc.name())
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '.', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+
+	return name + ".yaml"
+}
@@ -0,0 +1,95 @@
+package conf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteManifestsCRDs(t *testing.T) {
+	const (
+		crd = `---
+# Source: foo/crds/foo.yaml
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: foos.example.com
+`
+		cm = `---
+# Source: foo/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+`
+	)
+
+	dir, err := ioutil.TempDir("", "helm-stack-test-*")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	d := &DeploymentSpec{Name: "testing/foo", Chart: "foo@latest"}
+	for _, layout := range []string{ManifestsLayoutSingle, ManifestsLayoutSplit} {
+		e := Environment{Name: "test", ManifestsLayout: layout}
+		manifestsDir := filepath.Join(dir, layout)
+		if !assert.NoError(t, os.MkdirAll(manifestsDir, 0755)) {
+			return
+		}
+
+		if !assert.NoError(t, e.writeManifests(manifestsDir, d, []byte(crd+cm))) {
+			return
+		}
+
+		crdsFile, manifestsFile := e.crdsPath(manifestsDir, d), e.manifestsPath(manifestsDir, d)
+		if layout == ManifestsLayoutSplit {
+			crdsFile = filepath.Join(crdsFile, "customresourcedefinition-foos.example.com.yaml")
+			manifestsFile = filepath.Join(manifestsFile, "configmap-foo.yaml")
+		}
+
+		data, err := ioutil.ReadFile(crdsFile)
+		assert.NoError(t, err, layout)
+		assert.Equal(t, crd, string(data), layout)
+
+		data, err = ioutil.ReadFile(manifestsFile)
+		assert.NoError(t, err, layout)
+		assert.Equal(t, cm, string(data), layout)
+	}
+}
+
+func TestWriteManifestsOnlyCRDs(t *testing.T) {
+	const crd = `---
+# Source: foo/crds/foo.yaml
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: foos.example.com
+`
+
+	dir, err := ioutil.TempDir("", "helm-stack-test-*")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	d := &DeploymentSpec{Name: "testing/foo", Chart: "foo@latest"}
+	for _, layout := range []string{ManifestsLayoutSingle, ManifestsLayoutSplit} {
+		e := Environment{Name: "test", ManifestsLayout: layout}
+		manifestsDir := filepath.Join(dir, layout)
+		if !assert.NoError(t, os.MkdirAll(manifestsDir, 0755)) {
+			return
+		}
+
+		if !assert.NoError(t, e.writeManifests(manifestsDir, d, []byte(crd))) {
+			return
+		}
+
+		assert.True(t, hasManifestFiles(e.crdsPath(manifestsDir, d)), layout)
+		// nothing to apply besides crds
+		assert.False(t, hasManifestFiles(e.manifestsPath(manifestsDir, d)), layout)
+	}
+}
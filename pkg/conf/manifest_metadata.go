@@ -0,0 +1,138 @@
+package conf
+
+const (
+	LabelEnvironment = "helm-stack.arhat.dev/environment"
+	LabelDeployment  = "helm-stack.arhat.dev/deployment"
+)
+
+// commonMetadata are labels and annotations added to all rendered resources of a deployment
+type commonMetadata struct {
+	labels      map[string]string
+	annotations map[string]string
+
+	podTemplates bool
+}
+
+// commonMetadata resolves common metadata of the deployment, deployment level labels
+// and annotations take precedence over environment level ones
+func (e Environment) commonMetadata(d DeploymentSpec) commonMetadata {
+	ret := commonMetadata{
+		labels:       mergeStringMaps(e.CommonLabels, d.CommonLabels),
+		annotations:  mergeStringMaps(e.CommonAnnotations, d.CommonAnnotations),
+		podTemplates: e.CommonMetadataInPodTemplates,
+	}
+
+	if e.BuiltinLabels {
+		namespace, name := d.NamespaceAndName()
+		ret.labels = mergeStringMaps(ret.labels, map[string]string{
+			LabelEnvironment: e.Name,
+			LabelDeployment:  namespace + "." + name,
+		})
+	}
+
+	return ret
+}
+
+func (m commonMetadata) enabled() bool {
+	return len(m.labels) != 0 || len(m.annotations) != 0
+}
+
+// addCommonMetadata adds labels and annotations to all manifest documents, they are
+// inserted into the raw documents unless pod templates are also updated
+func addCommonMetadata(docs []*manifestDoc, m commonMetadata) {
+	for _, doc := range docs {
+		if doc.obj == nil {
+			continue
+		}
+
+		doc.setMetadataKeys("labels", m.labels)
+		doc.setMetadataKeys("annotations", m.annotations)
+		if !m.podTemplates {
+			continue
+		}
+
+		for _, md := range podTemplateMetadata(doc.obj) {
+			metadata, ok := md.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			labelsChanged := setKeys(metadata, "labels", m.labels)
+			annotationsChanged := setKeys(metadata, "annotations", m.annotations)
+			if labelsChanged || annotationsChanged {
+				doc.modified = true
+			}
+		}
+	}
+}
+
+// podTemplateMetadata finds metadata of pod templates (and job templates) in the object,
+// missing metadata is created
+func podTemplateMetadata(obj map[string]interface{}) []interface{} {
+	var ret []interface{}
+	spec, _ := obj["spec"].(map[string]interface{})
+	for spec != nil {
+		var template map[string]interface{}
+		for _, k := range []string{"template", "jobTemplate"} {
+			if t, ok := spec[k].(map[string]interface{}); ok {
+				template = t
+				break
+			}
+		}
+
+		if template == nil {
+			break
+		}
+
+		if _, ok := template["metadata"].(map[string]interface{}); !ok {
+			template["metadata"] = make(map[string]interface{})
+		}
+
+		ret = append(ret, template["metadata"])
+		spec, _ = template["spec"].(map[string]interface{})
+	}
+
+	return ret
+}
+
+// setKeys sets key values in metadata field, returns true if anything changed
+func setKeys(metadata map[string]interface{}, field string, kv map[string]string) bool {
+	if len(kv) == 0 {
+		return false
+	}
+
+	m, ok := metadata[field].(map[string]interface{})
+	if !ok {
+		m = make(map[string]interface{})
+		metadata[field] = m
+	}
+
+	changed := false
+	for k, v := range kv {
+		if current, ok := m[k].(string); ok && current == v {
+			continue
+		}
+
+		m[k] = v
+		changed = true
+	}
+
+	return changed
+}
+
+func mergeStringMaps(a, b map[string]string) map[string]string {
+	if len(a)+len(b) == 0 {
+		return nil
+	}
+
+	ret := make(map[string]string, len(a)+len(b))
+	for k, v := range a {
+		ret[k] = v
+	}
+
+	for k, v := range b {
+		ret[k] = v
+	}
+
+	return ret
+}
@@ -0,0 +1,154 @@
+package conf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddCommonMetadata(t *testing.T) {
+	const data = `---
+# Source: foo/templates/cronjob.yaml
+apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: foo
+spec:
+  jobTemplate:
+    spec:
+      template:
+        metadata:
+          labels:
+            app: foo
+`
+
+	e := Environment{
+		Name:                         "test",
+		CommonLabels:                 map[string]string{"team": "a", "cost-center": "1"},
+		BuiltinLabels:                true,
+		CommonMetadataInPodTemplates: true,
+	}
+	d := DeploymentSpec{
+		Name:              "default/foo",
+		CommonLabels:      map[string]string{"team": "b"},
+		CommonAnnotations: map[string]string{"owner": "b"},
+	}
+
+	docs, err := parseManifests([]byte(data))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	addCommonMetadata(docs, e.commonMetadata(d))
+	result, err := encodeManifests(docs)
+	assert.NoError(t, err)
+	assert.Equal(t, `---
+# Source: foo/templates/cronjob.yaml
+apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  annotations:
+    owner: b
+  labels:
+    cost-center: "1"
+    helm-stack.arhat.dev/deployment: default.foo
+    helm-stack.arhat.dev/environment: test
+    team: b
+  name: foo
+spec:
+  jobTemplate:
+    metadata:
+      annotations:
+        owner: b
+      labels:
+        cost-center: "1"
+        helm-stack.arhat.dev/deployment: default.foo
+        helm-stack.arhat.dev/environment: test
+        team: b
+    spec:
+      template:
+        metadata:
+          annotations:
+            owner: b
+          labels:
+            app: foo
+            cost-center: "1"
+            helm-stack.arhat.dev/deployment: default.foo
+            helm-stack.arhat.dev/environment: test
+            team: b
+`, string(result))
+}
+
+func TestAddCommonMetadata_Raw(t *testing.T) {
+	const data = `---
+# Source: foo/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+  labels:
+    app: foo
+data:
+  foo: bar
+`
+
+	for _, test := range []struct {
+		name     string
+		env      Environment
+		expected string
+	}{
+		{
+			name: "Builtin Labels",
+			env:  Environment{Name: "test", BuiltinLabels: true},
+			expected: `---
+# Source: foo/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+  labels:
+    app: foo
+    helm-stack.arhat.dev/deployment: default.foo
+    helm-stack.arhat.dev/environment: test
+  annotations:
+    owner: b
+data:
+  foo: bar
+`,
+		},
+		{
+			name: "No Builtin Labels",
+			env:  Environment{Name: "test"},
+			expected: `---
+# Source: foo/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+  labels:
+    app: foo
+  annotations:
+    owner: b
+data:
+  foo: bar
+`,
+		},
+	} {
+		docs, err := parseManifests([]byte(data))
+		if !assert.NoError(t, err, test.name) {
+			return
+		}
+
+		d := DeploymentSpec{
+			Name:              "default/foo",
+			CommonAnnotations: map[string]string{"owner": "b"},
+		}
+
+		addCommonMetadata(docs, test.env.commonMetadata(d))
+		assert.False(t, docs[0].modified, test.name)
+
+		result, err := encodeManifests(docs)
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.expected, string(result), test.name)
+	}
+}
@@ -0,0 +1,77 @@
+package conf
+
+// clusterScopedKinds are well-known cluster scoped kinds, in `<group>/<kind>` format
+var clusterScopedKinds = map[string]struct{}{
+	"/Namespace":        {},
+	"/Node":             {},
+	"/PersistentVolume": {},
+	"/ComponentStatus":  {},
+
+	"rbac.authorization.k8s.io/ClusterRole":        {},
+	"rbac.authorization.k8s.io/ClusterRoleBinding": {},
+
+	"apiextensions.k8s.io/CustomResourceDefinition": {},
+	"apiregistration.k8s.io/APIService":             {},
+
+	"admissionregistration.k8s.io/MutatingWebhookConfiguration":   {},
+	"admissionregistration.k8s.io/ValidatingWebhookConfiguration": {},
+
+	"storage.k8s.io/StorageClass":     {},
+	"storage.k8s.io/VolumeAttachment": {},
+	"storage.k8s.io/CSIDriver":        {},
+	"storage.k8s.io/CSINode":          {},
+
+	"policy/PodSecurityPolicy":     {},
+	"extensions/PodSecurityPolicy": {},
+
+	"scheduling.k8s.io/PriorityClass":               {},
+	"node.k8s.io/RuntimeClass":                      {},
+	"networking.k8s.io/IngressClass":                {},
+	"certificates.k8s.io/CertificateSigningRequest": {},
+
+	"flowcontrol.apiserver.k8s.io/FlowSchema":                 {},
+	"flowcontrol.apiserver.k8s.io/PriorityLevelConfiguration": {},
+
+	"authentication.k8s.io/TokenReview":            {},
+	"authorization.k8s.io/SubjectAccessReview":     {},
+	"authorization.k8s.io/SelfSubjectAccessReview": {},
+	"authorization.k8s.io/SelfSubjectRulesReview":  {},
+}
+
+// injectNamespace sets namespace of namespaced objects without namespace defined
+//
+// scope of objects are resolved from well-known kinds and custom resource definitions
+// in docs, objects of unknown kinds are treated as namespaced
+func injectNamespace(docs []*manifestDoc, namespace string) {
+	clusterScoped := make(map[string]struct{})
+	for _, doc := range docs {
+		if !isCRD(doc) {
+			continue
+		}
+
+		spec, _ := doc.obj["spec"].(map[string]interface{})
+		names, _ := spec["names"].(map[string]interface{})
+		group, _ := spec["group"].(string)
+		kind, _ := names["kind"].(string)
+		if scope, _ := spec["scope"].(string); scope == "Cluster" {
+			clusterScoped[group+"/"+kind] = struct{}{}
+		}
+	}
+
+	for _, doc := range docs {
+		if doc.obj == nil || doc.namespace() != "" {
+			continue
+		}
+
+		key := doc.group() + "/" + doc.kind()
+		if _, ok := clusterScopedKinds[key]; ok {
+			continue
+		}
+
+		if _, ok := clusterScoped[key]; ok {
+			continue
+		}
+
+		doc.setMetadataKeys("", map[string]string{"namespace": namespace})
+	}
+}
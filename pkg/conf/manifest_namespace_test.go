@@ -0,0 +1,158 @@
+package conf
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/yaml"
+)
+
+func TestInjectNamespace(t *testing.T) {
+	const data = `---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: foos.example.com
+spec:
+  group: example.com
+  names:
+    kind: Foo
+  scope: Cluster
+---
+apiVersion: example.com/v1
+kind: Foo
+metadata:
+  name: foo
+---
+apiVersion: example.com/v1
+kind: Bar
+metadata:
+  name: bar
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: foo
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: foo
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+  namespace: other
+`
+
+	docs, err := parseManifests([]byte(data))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	injectNamespace(docs, "testing")
+
+	var namespaces []string
+	for _, doc := range docs {
+		namespaces = append(namespaces, doc.namespace())
+	}
+	assert.Equal(t, []string{"", "", "testing", "", "testing", "other"}, namespaces)
+	for _, doc := range docs {
+		assert.False(t, doc.modified)
+	}
+
+	output, err := encodeManifests(docs)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	expected := strings.NewReplacer(
+		"kind: Bar\nmetadata:\n  name: bar\n", "kind: Bar\nmetadata:\n  name: bar\n  namespace: testing\n",
+		"kind: Role\nmetadata:\n  name: foo\n", "kind: Role\nmetadata:\n  name: foo\n  namespace: testing\n",
+	).Replace(data)
+	assert.Equal(t, expected, string(output))
+}
+
+func TestManifestDoc_setMetadataKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		field    string
+		doc      string
+		expected string
+		modified bool
+	}{
+		{
+			name: "Namespace After Name",
+			doc:  "kind: Foo\nmetadata:\n    labels:\n        a: b\n    name: foo\nspec: {}\n",
+			expected: "kind: Foo\nmetadata:\n    labels:\n        a: b\n    name: foo\n" +
+				"    namespace: testing\nspec: {}\n",
+		},
+		{
+			name:     "Namespace No Trailing Newline",
+			doc:      "kind: Foo\nmetadata:\n  name: foo",
+			expected: "kind: Foo\nmetadata:\n  name: foo\n  namespace: testing\n",
+		},
+		{
+			name:     "Namespace Flow Style",
+			doc:      "kind: Foo\nmetadata: {name: foo}\n",
+			modified: true,
+		},
+		{
+			name:     "Namespace No Metadata",
+			doc:      "kind: Foo\n",
+			modified: true,
+		},
+		{
+			name:     "New Labels",
+			field:    "labels",
+			doc:      "kind: Foo\nmetadata:\n  name: foo\n  # comment\nspec: {}\n",
+			expected: "kind: Foo\nmetadata:\n  name: foo\n  labels:\n    a: \"1\"\n    b: c\n  # comment\nspec: {}\n",
+		},
+		{
+			name:     "Existing Labels",
+			field:    "labels",
+			doc:      "kind: Foo\nmetadata:\n  labels:\n    a: \"1\"\n  name: foo\n",
+			expected: "kind: Foo\nmetadata:\n  labels:\n    a: \"1\"\n    b: c\n  name: foo\n",
+		},
+		{
+			name:     "Conflicting Labels",
+			field:    "labels",
+			doc:      "kind: Foo\nmetadata:\n  labels:\n    b: d\n  name: foo\n",
+			modified: true,
+		},
+		{
+			name:     "Empty Labels",
+			field:    "labels",
+			doc:      "kind: Foo\nmetadata:\n  labels: {}\n  name: foo\n",
+			modified: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			docs, err := parseManifests([]byte(test.doc))
+			if !assert.NoError(t, err) || !assert.Len(t, docs, 1) {
+				return
+			}
+
+			kv := map[string]string{"namespace": "testing"}
+			if test.field != "" {
+				kv = map[string]string{"a": "1", "b": "c"}
+			}
+
+			doc := docs[0]
+			assert.True(t, doc.setMetadataKeys(test.field, kv))
+			assert.False(t, doc.setMetadataKeys(test.field, kv))
+			assert.Equal(t, test.modified, doc.modified)
+			if !test.modified {
+				assert.Equal(t, test.expected, string(doc.raw))
+
+				obj := make(map[string]interface{})
+				assert.NoError(t, yaml.Unmarshal(doc.raw, &obj))
+				assert.EqualValues(t, doc.obj, obj)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package conf
+
+import (
+	"sort"
+)
+
+// ManifestsNormalization are options to normalize generated manifests, so that diffs of
+// manifests only show real changes
+type ManifestsNormalization struct {
+	// Sort resources by kind in helm install order, then by namespace and name
+	Sort bool `json:"sort" yaml:"sort"`
+
+	// Format all resources as canonical yaml with keys sorted
+	Format bool `json:"format" yaml:"format"`
+
+	// StripComments removes all comments including `# Source: `
+	StripComments bool `json:"stripComments" yaml:"stripComments"`
+
+	// StripLabels and StripAnnotations are keys removed from labels and annotations of
+	// resources and their pod templates (e.g. `helm.sh/chart`)
+	StripLabels      []string `json:"stripLabels" yaml:"stripLabels"`
+	StripAnnotations []string `json:"stripAnnotations" yaml:"stripAnnotations"`
+}
+
+func (n ManifestsNormalization) Enabled() bool {
+	return n.Sort || n.Format || n.StripComments || len(n.StripLabels) != 0 || len(n.StripAnnotations) != 0
+}
+
+// kindInstallOrder is the same as helm (releaseutil.InstallOrder)
+var kindInstallOrder = []string{
+	"Namespace",
+	"NetworkPolicy",
+	"ResourceQuota",
+	"LimitRange",
+	"PodSecurityPolicy",
+	"PodDisruptionBudget",
+	"ServiceAccount",
+	"Secret",
+	"SecretList",
+	"ConfigMap",
+	"StorageClass",
+	"PersistentVolume",
+	"PersistentVolumeClaim",
+	"CustomResourceDefinition",
+	"ClusterRole",
+	"ClusterRoleList",
+	"ClusterRoleBinding",
+	"ClusterRoleBindingList",
+	"Role",
+	"RoleList",
+	"RoleBinding",
+	"RoleBindingList",
+	"Service",
+	"DaemonSet",
+	"Pod",
+	"ReplicationController",
+	"ReplicaSet",
+	"Deployment",
+	"HorizontalPodAutoscaler",
+	"StatefulSet",
+	"Job",
+	"CronJob",
+	"Ingress",
+	"APIService",
+}
+
+// normalizeManifests normalizes manifest documents, empty documents are removed
+func normalizeManifests(docs []*manifestDoc, n ManifestsNormalization) []*manifestDoc {
+	var ret []*manifestDoc
+	for _, doc := range docs {
+		if doc.obj == nil {
+			continue
+		}
+
+		if stripMetadata(doc.obj, n.StripLabels, n.StripAnnotations) || n.Format || n.StripComments {
+			doc.modified = true
+		}
+
+		if n.StripComments {
+			doc.source = ""
+		}
+
+		ret = append(ret, doc)
+	}
+
+	if n.Sort {
+		order := make(map[string]int, len(kindInstallOrder))
+		for i, k := range kindInstallOrder {
+			order[k] = i
+		}
+
+		kindOrder := func(kind string) int {
+			if i, ok := order[kind]; ok {
+				return i
+			}
+
+			// unknown kinds are installed last
+			return len(kindInstallOrder)
+		}
+
+		sort.SliceStable(ret, func(i, j int) bool {
+			a, b := ret[i], ret[j]
+			if oa, ob := kindOrder(a.kind()), kindOrder(b.kind()); oa != ob {
+				return oa < ob
+			}
+
+			if a.kind() != b.kind() {
+				return a.kind() < b.kind()
+			}
+
+			if a.namespace() != b.namespace() {
+				return a.namespace() < b.namespace()
+			}
+
+			return a.name() < b.name()
+		})
+	}
+
+	return ret
+}
+
+// stripMetadata removes label and annotation keys from all `metadata` in obj (including
+// pod templates), returns true if anything removed
+func stripMetadata(obj map[string]interface{}, labelKeys, annotationKeys []string) bool {
+	if len(labelKeys) == 0 && len(annotationKeys) == 0 {
+		return false
+	}
+
+	removed := false
+	for k, v := range obj {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			if k == "metadata" {
+				removed = deleteKeys(t, "labels", labelKeys) || removed
+				removed = deleteKeys(t, "annotations", annotationKeys) || removed
+			}
+
+			removed = stripMetadata(t, labelKeys, annotationKeys) || removed
+		case []interface{}:
+			for _, item := range t {
+				if m, ok := item.(map[string]interface{}); ok {
+					removed = stripMetadata(m, labelKeys, annotationKeys) || removed
+				}
+			}
+		}
+	}
+
+	return removed
+}
+
+func deleteKeys(metadata map[string]interface{}, field string, keys []string) bool {
+	m, ok := metadata[field].(map[string]interface{})
+	if !ok {
+		return false
+	}
+
+	removed := false
+	for _, k := range keys {
+		if _, ok := m[k]; ok {
+			delete(m, k)
+			removed = true
+		}
+	}
+
+	if removed && len(m) == 0 {
+		delete(metadata, field)
+	}
+
+	return removed
+}
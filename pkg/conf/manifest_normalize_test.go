@@ -0,0 +1,103 @@
+package conf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeManifests(t *testing.T) {
+	const data = `---
+# Source: foo/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+  labels:
+    app: foo
+    helm.sh/chart: foo-0.1.0
+spec:
+  selector:
+    matchLabels:
+      app: foo
+  template:
+    metadata:
+      labels:
+        helm.sh/chart: foo-0.1.0
+---
+# Source: foo/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+`
+
+	docs, err := parseManifests([]byte(data))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	result, err := encodeManifests(normalizeManifests(docs, ManifestsNormalization{
+		Sort:          true,
+		StripComments: true,
+		StripLabels:   []string{"helm.sh/chart"},
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  labels:
+    app: foo
+  name: foo
+spec:
+  selector:
+    matchLabels:
+      app: foo
+  template:
+    metadata: {}
+`, string(result))
+}
+
+func TestNormalizeManifests_SortRaw(t *testing.T) {
+	// first document without separator, last one without trailing newline
+	const data = `kind: Service
+apiVersion: v1
+metadata:
+  name: foo
+---
+kind: Namespace
+apiVersion: v1
+metadata:
+  name: foo`
+
+	docs, err := parseManifests([]byte(data))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	result, err := encodeManifests(normalizeManifests(docs, ManifestsNormalization{Sort: true}))
+	assert.NoError(t, err)
+	assert.Equal(t, `---
+kind: Namespace
+apiVersion: v1
+metadata:
+  name: foo
+---
+kind: Service
+apiVersion: v1
+metadata:
+  name: foo
+`, string(result))
+
+	docs, err = parseManifests(result)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, docs, 2)
+}
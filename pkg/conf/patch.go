@@ -0,0 +1,249 @@
+package conf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	PatchTypeStrategicMerge = "strategic"
+	PatchTypeJSON6902       = "json6902"
+)
+
+// PatchSpec is a patch applied to rendered manifests of a deployment
+type PatchSpec struct {
+	// Type of the patch, one of `strategic` (default) and `json6902`
+	Type string `json:"type" yaml:"type"`
+
+	// Target selects objects to patch, for strategic merge patch, kind and name default to
+	// the kind and name in the patch
+	Target PatchTarget `json:"target" yaml:"target"`
+
+	// Patch content (yaml or json)
+	Patch string `json:"patch" yaml:"patch"`
+
+	// File containing patch content, relative to the custom manifests dir of the deployment,
+	// files used as patches are not applied as custom manifests
+	File string `json:"file" yaml:"file"`
+}
+
+type PatchTarget struct {
+	Group     string `json:"group" yaml:"group"`
+	Version   string `json:"version" yaml:"version"`
+	Kind      string `json:"kind" yaml:"kind"`
+	Name      string `json:"name" yaml:"name"`
+	Namespace string `json:"namespace" yaml:"namespace"`
+
+	// LabelSelector in the same format as `kubectl --selector`
+	LabelSelector string `json:"labelSelector" yaml:"labelSelector"`
+}
+
+func (p PatchSpec) Validate() error {
+	switch p.Type {
+	case "", PatchTypeStrategicMerge:
+	case PatchTypeJSON6902:
+		if p.Target.Kind == "" {
+			return fmt.Errorf("json6902 patch requires target kind")
+		}
+	default:
+		return fmt.Errorf("invalid patch type %q, expecting one of %q, %q",
+			p.Type, PatchTypeStrategicMerge, PatchTypeJSON6902)
+	}
+
+	if (p.Patch == "") == (p.File == "") {
+		return fmt.Errorf("exact one of patch and file is required")
+	}
+
+	if p.Target.LabelSelector != "" {
+		if _, err := labels.Parse(p.Target.LabelSelector); err != nil {
+			return fmt.Errorf("invalid label selector %q: %w", p.Target.LabelSelector, err)
+		}
+	}
+
+	return nil
+}
+
+// PatchFiles returns absolute path of patch files of the deployment
+func (e Environment) PatchFiles(envDir string, d *DeploymentSpec) []string {
+	var ret []string
+	for _, p := range d.Patches {
+		if p.File != "" {
+			ret = append(ret, e.patchFilePath(envDir, d, p.File))
+		}
+	}
+
+	return ret
+}
+
+func (e Environment) patchFilePath(envDir string, d *DeploymentSpec, file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+
+	return filepath.Join(e.CustomManifestsDir(envDir, d), file)
+}
+
+// applyPatches applies patches of the deployment to manifest documents in order
+func (e Environment) applyPatches(envDir string, d DeploymentSpec, docs []*manifestDoc) error {
+	for i, p := range d.Patches {
+		name := fmt.Sprintf("patches[%d]", i)
+
+		data := []byte(p.Patch)
+		if p.File != "" {
+			file := e.patchFilePath(envDir, &d, p.File)
+			name = file
+
+			var err error
+			data, err = ioutil.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("failed to read patch file: %w", err)
+			}
+		}
+
+		patchJSON, err := yaml.YAMLToJSON(data)
+		if err != nil {
+			return fmt.Errorf("failed to parse patch %s: %w", name, err)
+		}
+
+		err = applyPatch(p, patchJSON, docs)
+		if err != nil {
+			return fmt.Errorf("failed to apply patch %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func applyPatch(p PatchSpec, patchJSON []byte, docs []*manifestDoc) error {
+	target := p.Target
+
+	var (
+		jsonPatch jsonpatch.Patch
+		err       error
+	)
+
+	switch p.Type {
+	case PatchTypeJSON6902:
+		jsonPatch, err = jsonpatch.DecodePatch(patchJSON)
+		if err != nil {
+			return fmt.Errorf("invalid json6902 patch: %w", err)
+		}
+	default:
+		patchObj := make(map[string]interface{})
+		err = json.Unmarshal(patchJSON, &patchObj)
+		if err != nil {
+			return fmt.Errorf("invalid strategic merge patch: %w", err)
+		}
+
+		patchDoc := &manifestDoc{obj: patchObj}
+		if target.Kind == "" {
+			target.Kind = patchDoc.kind()
+		}
+
+		if target.Name == "" {
+			target.Name = patchDoc.name()
+		}
+	}
+
+	var selector labels.Selector
+	if target.LabelSelector != "" {
+		selector, err = labels.Parse(target.LabelSelector)
+		if err != nil {
+			return fmt.Errorf("invalid label selector %q: %w", target.LabelSelector, err)
+		}
+	}
+
+	matched := false
+	for _, doc := range docs {
+		if doc.obj == nil || !target.matches(doc, selector) {
+			continue
+		}
+		matched = true
+
+		docJSON, err := json.Marshal(doc.obj)
+		if err != nil {
+			return fmt.Errorf("failed to encode manifest %q: %w", doc.source, err)
+		}
+
+		var result []byte
+		if jsonPatch != nil {
+			result, err = jsonPatch.Apply(docJSON)
+		} else {
+			result, err = strategicMergePatch(doc, docJSON, patchJSON)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to patch %s %q: %w", doc.kind(), doc.name(), err)
+		}
+
+		obj := make(map[string]interface{})
+		err = json.Unmarshal(result, &obj)
+		if err != nil {
+			return fmt.Errorf("failed to decode patched manifest: %w", err)
+		}
+
+		if len(obj) == 0 {
+			// deleted by patch (e.g. `$patch: delete`)
+			obj = nil
+		}
+
+		doc.obj = obj
+		doc.modified = true
+	}
+
+	if !matched {
+		return fmt.Errorf("no object matched target %s", target)
+	}
+
+	return nil
+}
+
+// strategicMergePatch applies strategic merge patch for kubernetes builtin types, and json merge
+// patch for other types (e.g. custom resources)
+func strategicMergePatch(doc *manifestDoc, docJSON, patchJSON []byte) ([]byte, error) {
+	gvk := schema.FromAPIVersionAndKind(doc.apiVersion(), doc.kind())
+	obj, err := scheme.Scheme.New(gvk)
+	if err != nil {
+		return jsonpatch.MergePatch(docJSON, patchJSON)
+	}
+
+	return strategicpatch.StrategicMergePatch(docJSON, patchJSON, obj)
+}
+
+func (t PatchTarget) matches(doc *manifestDoc, selector labels.Selector) bool {
+	gv, _ := schema.ParseGroupVersion(doc.apiVersion())
+
+	switch {
+	case t.Group != "" && t.Group != gv.Group,
+		t.Version != "" && t.Version != gv.Version,
+		t.Kind != "" && t.Kind != doc.kind(),
+		t.Name != "" && t.Name != doc.name(),
+		t.Namespace != "" && t.Namespace != doc.namespace():
+		return false
+	}
+
+	return selector == nil || selector.Matches(labels.Set(doc.labels()))
+}
+
+func (t PatchTarget) String() string {
+	var parts []string
+	for _, kv := range [][2]string{
+		{"group", t.Group}, {"version", t.Version}, {"kind", t.Kind},
+		{"name", t.Name}, {"namespace", t.Namespace}, {"labelSelector", t.LabelSelector},
+	} {
+		if kv[1] != "" {
+			parts = append(parts, kv[0]+"="+kv[1])
+		}
+	}
+
+	return "{" + strings.Join(parts, ", ") + "}"
+}
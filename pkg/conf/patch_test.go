@@ -0,0 +1,150 @@
+package conf
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testPatchDeployment = `---
+# Source: foo/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+  labels:
+    app: foo
+spec:
+  template:
+    spec:
+      containers:
+      - name: foo
+        image: foo:v1
+`
+	testPatchConfigMap = `---
+# Source: foo/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo # keep comment
+data:
+  foo: bar
+`
+)
+
+func TestParseManifestsUnmodified(t *testing.T) {
+	data, err := ioutil.ReadFile("../../testdata/expected-manifests.yaml")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	docs, err := parseManifests(data)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, docs, 4)
+	assert.Equal(t, "foo/templates/configmap.yaml", docs[3].source)
+
+	result, err := encodeManifests(docs)
+	assert.NoError(t, err)
+	assert.Equal(t, string(data), string(result))
+}
+
+func TestApplyPatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		patch    PatchSpec
+		expected string
+		err      bool
+	}{
+		{
+			name: "Strategic Merge",
+			patch: PatchSpec{Patch: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+spec:
+  template:
+    spec:
+      containers:
+      - name: sidecar
+        image: sidecar:v1
+`},
+			expected: `---
+# Source: foo/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  labels:
+    app: foo
+  name: foo
+spec:
+  template:
+    spec:
+      containers:
+      - image: sidecar:v1
+        name: sidecar
+      - image: foo:v1
+        name: foo
+`,
+		},
+		{
+			name: "JSON6902 With Label Selector",
+			patch: PatchSpec{
+				Type:   PatchTypeJSON6902,
+				Target: PatchTarget{Kind: "Deployment", LabelSelector: "app=foo"},
+				Patch:  `[{"op": "replace", "path": "/spec/template/spec/containers/0/image", "value": "foo:v2"}]`,
+			},
+			expected: `---
+# Source: foo/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  labels:
+    app: foo
+  name: foo
+spec:
+  template:
+    spec:
+      containers:
+      - image: foo:v2
+        name: foo
+`,
+		},
+		{
+			name: "No Target Matched",
+			patch: PatchSpec{
+				Type:   PatchTypeJSON6902,
+				Target: PatchTarget{Kind: "Deployment", Name: "bar"},
+				Patch:  `[{"op": "remove", "path": "/spec"}]`,
+			},
+			err: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			docs, err := parseManifests([]byte(testPatchDeployment + testPatchConfigMap))
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			err = Environment{}.applyPatches("", DeploymentSpec{Patches: []PatchSpec{test.patch}}, docs)
+			if test.err {
+				assert.Error(t, err)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			result, err := encodeManifests(docs)
+			assert.NoError(t, err)
+			// unmodified documents are kept as is
+			assert.Equal(t, test.expected+testPatchConfigMap, string(result))
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package conf
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultRenderCacheDir returns `<user cache dir>/helm-stack/manifests`
+func DefaultRenderCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine user cache dir: %w", err)
+	}
+
+	return filepath.Join(dir, "helm-stack", "manifests"), nil
+}
+
+// NewRenderCache creates a cache for rendered manifests stored in dir, keyed by content hash
+// of chart, values, renderer version and render options
+func NewRenderCache(dir string) *RenderCache {
+	return &RenderCache{
+		dir:         dir,
+		chartHashes: make(map[string]string),
+		mu:          new(sync.Mutex),
+	}
+}
+
+type RenderCache struct {
+	dir string
+
+	// chart dir -> content hash, chart dirs are only hashed once
+	chartHashes map[string]string
+	mu          *sync.Mutex
+}
+
+// Key generates cache key for the render request
+func (c *RenderCache) Key(ctx context.Context, renderer Renderer, req *RenderRequest) (string, error) {
+	rendererVersion, err := renderer.Version(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	chartHash, err := c.hashChart(req.ChartDir)
+	if err != nil {
+		return "", err
+	}
+
+	// json encoded map keys are sorted, output is stable
+	reqBytes, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode render request: %w", err)
+	}
+
+	h := sha256.New()
+	for _, v := range [][]byte{[]byte(rendererVersion), []byte(chartHash), reqBytes} {
+		_, _ = h.Write(v)
+		_, _ = h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Get cached manifests, returns false if not found
+func (c *RenderCache) Get(key string) ([]byte, bool) {
+	data, err := ioutil.ReadFile(filepath.Join(c.dir, key+".yaml"))
+	if err != nil {
+		return nil, false
+	}
+
+	return data, true
+}
+
+// Put manifests into cache
+func (c *RenderCache) Put(key string, data []byte) error {
+	err := os.MkdirAll(c.dir, 0700)
+	if err != nil {
+		return fmt.Errorf("failed to ensure render cache dir %q: %w", c.dir, err)
+	}
+
+	return writeFileAtomic(filepath.Join(c.dir, key+".yaml"), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+func (c *RenderCache) hashChart(chartDir string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if h, ok := c.chartHashes[chartDir]; ok {
+		return h, nil
+	}
+
+	var files []string
+	err := filepath.Walk(chartDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			files = append(files, path)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to walk chart dir %q: %w", chartDir, err)
+	}
+	sort.Strings(files)
+
+	h := sha256.New()
+	for _, f := range files {
+		rel, _ := filepath.Rel(chartDir, f)
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return "", fmt.Errorf("failed to read chart file %q: %w", f, err)
+		}
+
+		_, _ = h.Write([]byte(filepath.ToSlash(rel)))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write(hashBytes(data))
+	}
+
+	ret := hex.EncodeToString(h.Sum(nil))
+	c.chartHashes[chartDir] = ret
+
+	return ret, nil
+}
+
+func hashBytes(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// GenStats counts deployments rendered and reused from cache
+type GenStats struct {
+	rendered int64
+	reused   int64
+}
+
+func (s *GenStats) Rendered() int64 {
+	return atomic.LoadInt64(&s.rendered)
+}
+
+func (s *GenStats) Reused() int64 {
+	return atomic.LoadInt64(&s.reused)
+}
+
+func (s *GenStats) addRendered() {
+	if s != nil {
+		atomic.AddInt64(&s.rendered, 1)
+	}
+}
+
+func (s *GenStats) addReused() {
+	if s != nil {
+		atomic.AddInt64(&s.reused, 1)
+	}
+}
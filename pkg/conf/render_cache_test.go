@@ -0,0 +1,182 @@
+package conf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testRenderer struct {
+	version    string
+	versionErr error
+	renders    int
+}
+
+func (r *testRenderer) Render(_ context.Context, req *RenderRequest, out io.Writer) error {
+	r.renders++
+	_, err := fmt.Fprintf(out, "# %s/%s\n", req.Namespace, req.ReleaseName)
+	return err
+}
+
+func (r *testRenderer) Version(_ context.Context) (string, error) {
+	return r.version, r.versionErr
+}
+
+func TestRenderCache_Key(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-stack-test-*")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	chartDir := filepath.Join(dir, "chart")
+	if !writeTestFiles(t, chartDir, map[string]string{
+		"Chart.yaml":             "name: foo\n",
+		"values.yaml":            "foo: bar\n",
+		"templates/service.yaml": "kind: Service\n",
+	}) {
+		return
+	}
+
+	newRequest := func() *RenderRequest {
+		return &RenderRequest{
+			Namespace:   "default",
+			ReleaseName: "foo",
+			ChartDir:    chartDir,
+			Values: map[string]interface{}{
+				"a": "a", "b": map[string]interface{}{"c": 1, "d": []interface{}{"e"}}, "f": true,
+			},
+			Log: ioutil.Discard,
+		}
+	}
+
+	renderer := &testRenderer{version: "test/v1"}
+	key := func(req *RenderRequest) string {
+		// new cache to hash chart again
+		k, err := NewRenderCache(dir).Key(context.TODO(), renderer, req)
+		assert.NoError(t, err)
+		return k
+	}
+
+	expected := key(newRequest())
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, expected, key(newRequest()), "key not stable")
+	}
+
+	req := newRequest()
+	req.Log = os.Stdout
+	assert.Equal(t, expected, key(req), "log changed key")
+
+	t.Run("Values Changed", func(t *testing.T) {
+		req := newRequest()
+		req.Values["b"].(map[string]interface{})["d"] = []interface{}{"e", "f"}
+		assert.NotEqual(t, expected, key(req))
+	})
+
+	t.Run("Options Changed", func(t *testing.T) {
+		req := newRequest()
+		req.Hooks = true
+		assert.NotEqual(t, expected, key(req))
+
+		req = newRequest()
+		req.APIVersions = []string{"example.com/v1"}
+		assert.NotEqual(t, expected, key(req))
+	})
+
+	t.Run("Renderer Version Changed", func(t *testing.T) {
+		renderer.version = "test/v2"
+		defer func() { renderer.version = "test/v1" }()
+
+		assert.NotEqual(t, expected, key(newRequest()))
+	})
+
+	t.Run("Chart Changed", func(t *testing.T) {
+		file := filepath.Join(chartDir, "templates", "service.yaml")
+		assert.NoError(t, ioutil.WriteFile(file, []byte("kind: Service\nmetadata: {}\n"), 0644))
+		assert.NotEqual(t, expected, key(newRequest()))
+
+		assert.NoError(t, ioutil.WriteFile(file, []byte("kind: Service\n"), 0644))
+		assert.Equal(t, expected, key(newRequest()))
+
+		// renamed file
+		assert.NoError(t, os.Rename(file, filepath.Join(chartDir, "templates", "svc.yaml")))
+		assert.NotEqual(t, expected, key(newRequest()))
+	})
+
+	t.Run("Renderer Version Error", func(t *testing.T) {
+		r := &testRenderer{versionErr: fmt.Errorf("no helm")}
+		_, err := NewRenderCache(dir).Key(context.TODO(), r, newRequest())
+		assert.Error(t, err)
+	})
+}
+
+func TestEnvironment_render(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-stack-test-*")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	req := &RenderRequest{
+		Namespace:   "default",
+		ReleaseName: "foo",
+		ChartDir:    dir,
+		Log:         ioutil.Discard,
+	}
+
+	e := Environment{Name: "test"}
+	renderer := &testRenderer{version: "test/v1"}
+	opts := GenOptions{
+		Renderer: renderer,
+		Cache:    NewRenderCache(filepath.Join(dir, "cache")),
+		Stats:    new(GenStats),
+	}
+
+	for i := 0; i < 2; i++ {
+		data, err := e.render(context.TODO(), req, opts)
+		assert.NoError(t, err)
+		assert.Equal(t, "# default/foo\n", string(data))
+	}
+	assert.Equal(t, 1, renderer.renders)
+	assert.EqualValues(t, 1, opts.Stats.Rendered())
+	assert.EqualValues(t, 1, opts.Stats.Reused())
+
+	opts.Force = true
+	_, err = e.render(context.TODO(), req, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, renderer.renders)
+
+	t.Run("Cache Errors Not Fatal", func(t *testing.T) {
+		log := new(bytes.Buffer)
+		req := *req
+		req.Log = log
+
+		// key error
+		data, err := e.render(context.TODO(), &req, GenOptions{
+			Renderer: &testRenderer{versionErr: fmt.Errorf("no helm")},
+			Cache:    NewRenderCache(filepath.Join(dir, "cache")),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "# default/foo\n", string(data))
+		assert.Contains(t, log.String(), "no helm")
+
+		// put error
+		log.Reset()
+		invalidCacheDir := filepath.Join(dir, "file")
+		assert.NoError(t, ioutil.WriteFile(invalidCacheDir, nil, 0644))
+		data, err = e.render(context.TODO(), &req, GenOptions{
+			Renderer: &testRenderer{version: "test/v1"},
+			Cache:    NewRenderCache(invalidCacheDir),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "# default/foo\n", string(data))
+		assert.Contains(t, log.String(), "Failed to cache manifests for default/foo")
+	})
+}
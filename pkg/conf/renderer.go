@@ -0,0 +1,77 @@
+package conf
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// RenderRequest is the input to render manifests of a deployment
+type RenderRequest struct {
+	Namespace   string
+	ReleaseName string
+	ChartDir    string
+
+	// ValuesFiles are applied in order before Values
+	ValuesFiles []string
+	// Values of the deployment with all user values merged and resolved
+	Values map[string]interface{}
+	// Set values in `<key>=<value>` format (as `helm --set`), applied after all values
+	Set []string
+
+	IncludeCRDs bool
+	// Hooks includes hook resources in rendered manifests as `helm template` without `--no-hooks`
+	Hooks bool `json:",omitempty"`
+
+	// KubeVersion and APIVersions are capabilities of the cluster, use renderer defaults if not set
+	KubeVersion string   `json:",omitempty"`
+	APIVersions []string `json:",omitempty"`
+
+	// Log receives messages of the renderer, defaults to os.Stdout
+	Log io.Writer `json:"-"`
+}
+
+// Renderer renders chart manifests in the same format as `helm template`
+type Renderer interface {
+	Render(ctx context.Context, req *RenderRequest, out io.Writer) error
+
+	// Version of the renderer, rendered manifests are cached with it
+	Version(ctx context.Context) (string, error)
+}
+
+const (
+	// RendererSDK renders charts in-process with the helm sdk
+	RendererSDK = "sdk"
+	// RendererExec runs `helm template` in a child process, as a fallback for charts
+	// requiring features of another helm version
+	RendererExec = "exec"
+
+	DefaultRenderer = RendererSDK
+)
+
+var renderers = map[string]func() (Renderer, error){
+	RendererSDK:  func() (Renderer, error) { return NewSDKRenderer(), nil },
+	RendererExec: func() (Renderer, error) { return NewExecRenderer(), nil },
+}
+
+// NewRenderer creates a renderer by name, empty name means DefaultRenderer
+func NewRenderer(name string) (Renderer, error) {
+	if name == "" {
+		name = DefaultRenderer
+	}
+
+	newRenderer, ok := renderers[name]
+	if !ok {
+		var names []string
+		for n := range renderers {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+
+		return nil, fmt.Errorf("unknown renderer %q, expecting one of [%s]", name, strings.Join(names, ", "))
+	}
+
+	return newRenderer()
+}
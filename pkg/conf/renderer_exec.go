@@ -0,0 +1,130 @@
+package conf
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"arhat.dev/pkg/exechelper"
+	"github.com/rogpeppe/go-internal/semver"
+	"sigs.k8s.io/yaml"
+)
+
+// NewExecRenderer creates a renderer running `helm template` for every deployment
+func NewExecRenderer() Renderer {
+	return &execRenderer{}
+}
+
+type execRenderer struct{}
+
+func (r *execRenderer) Version(_ context.Context) (string, error) {
+	ver := getHelmVersion()
+	if ver == "" {
+		return "", fmt.Errorf("failed to check helm version")
+	}
+
+	return RendererExec + "/" + ver, nil
+}
+
+// helmTemplateCommand builds the `helm template` command of helmVersion for req, values are
+// not included
+func helmTemplateCommand(req *RenderRequest, helmVersion string) ([]string, error) {
+	cmd := []string{"helm", "template", "--namespace", req.Namespace, "--debug"}
+	for _, f := range req.ValuesFiles {
+		cmd = append(cmd, "--values", f)
+	}
+
+	for _, s := range req.Set {
+		cmd = append(cmd, "--set", s)
+	}
+
+	if req.KubeVersion != "" {
+		// `--kube-version` is only available in `helm template` since helm v3.6
+		if helmVersion == "" || semver.Compare(helmVersion, "v3.6.0") < 0 {
+			return nil, fmt.Errorf(
+				"kubeVersion %q requires helm v3.6.0 or later to render with %q renderer, found helm %q, "+
+					"use %q renderer instead", req.KubeVersion, RendererExec, helmVersion, RendererSDK,
+			)
+		}
+
+		cmd = append(cmd, "--kube-version", req.KubeVersion)
+	}
+
+	for _, v := range req.APIVersions {
+		cmd = append(cmd, "--api-versions", v)
+	}
+
+	// default to helm3 when helm version unknown
+	if helmVersion == "" || semver.Compare(helmVersion, "v3") >= 0 {
+		if !req.Hooks {
+			cmd = append(cmd, "--no-hooks")
+		}
+
+		if req.IncludeCRDs {
+			cmd = append(cmd, "--include-crds")
+		} else {
+			cmd = append(cmd, "--skip-crds")
+		}
+		cmd = append(cmd, req.ReleaseName, req.ChartDir)
+	} else {
+		cmd = append(cmd, req.ChartDir, req.ReleaseName)
+	}
+
+	return cmd, nil
+}
+
+func (r *execRenderer) Render(ctx context.Context, req *RenderRequest, out io.Writer) error {
+	log := req.Log
+	if log == nil {
+		log = os.Stdout
+	}
+
+	cmd, err := helmTemplateCommand(req, getHelmVersion())
+	if err != nil {
+		return err
+	}
+
+	valuesBytes, err := yaml.Marshal(req.Values)
+	if err != nil {
+		return fmt.Errorf("failed to marshal values: %w", err)
+	}
+
+	tempValuesFile, err := ioutil.TempFile(os.TempDir(), "helm-stack-values-*.yaml")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary values file: %w", err)
+	}
+
+	defer func() {
+		// best effort
+		_ = os.Remove(tempValuesFile.Name())
+	}()
+
+	_, err = tempValuesFile.Write(valuesBytes)
+	_ = tempValuesFile.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write temporary values file %q: %w", tempValuesFile.Name(), err)
+	}
+
+	cmd = assembleCommandWithoutEmptyString(cmd, "--values", tempValuesFile.Name())
+
+	_, _ = fmt.Fprintln(log, "Executing:", strings.Join(cmd, " "))
+	proc, err := exechelper.Do(exechelper.Spec{
+		Context: ctx,
+		Command: cmd,
+		Stdout:  out,
+		Stderr:  log,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to execute helm template command: %w", err)
+	}
+
+	_, err = proc.Wait()
+	if err != nil {
+		return fmt.Errorf("failed to generate manifest: %w", err)
+	}
+
+	return nil
+}
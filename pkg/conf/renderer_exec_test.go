@@ -0,0 +1,59 @@
+package conf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHelmTemplateCommand(t *testing.T) {
+	req := &RenderRequest{
+		Namespace:   "testing",
+		ReleaseName: "foo",
+		ChartDir:    "charts/foo",
+		ValuesFiles: []string{"charts/foo/values.yaml"},
+		Set:         []string{"fullnameOverride=foo"},
+		IncludeCRDs: true,
+		APIVersions: []string{"example.com/v1"},
+	}
+
+	cmd, err := helmTemplateCommand(req, "v3.4.2+g23dd3af")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"helm", "template", "--namespace", "testing", "--debug",
+		"--values", "charts/foo/values.yaml",
+		"--set", "fullnameOverride=foo",
+		"--api-versions", "example.com/v1",
+		"--no-hooks", "--include-crds",
+		"foo", "charts/foo",
+	}, cmd)
+
+	req.KubeVersion = "v1.19.0"
+	req.Hooks = true
+	req.IncludeCRDs = false
+
+	for _, ver := range []string{"", "v3.4.2+g23dd3af", "v3.5.1+g32c2223"} {
+		_, err = helmTemplateCommand(req, ver)
+		if assert.Error(t, err, ver) {
+			assert.Contains(t, err.Error(), "requires helm v3.6.0 or later", ver)
+		}
+	}
+
+	cmd, err = helmTemplateCommand(req, "v3.6.0+g7f2df64")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"helm", "template", "--namespace", "testing", "--debug",
+		"--values", "charts/foo/values.yaml",
+		"--set", "fullnameOverride=foo",
+		"--kube-version", "v1.19.0",
+		"--api-versions", "example.com/v1",
+		"--skip-crds",
+		"foo", "charts/foo",
+	}, cmd)
+
+	req.KubeVersion = ""
+	cmd, err = helmTemplateCommand(req, "v2.16.0+ge13bc94")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"charts/foo", "foo"}, cmd[len(cmd)-2:])
+	assert.NotContains(t, cmd, "--skip-crds")
+}
@@ -0,0 +1,165 @@
+package conf
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"helm.sh/helm/v3/pkg/strvals"
+	"sigs.k8s.io/yaml"
+)
+
+// NewSDKRenderer creates a renderer rendering charts in-process with helm `action.Install`
+// in client only dry run mode, the same way as `helm template`
+func NewSDKRenderer() Renderer {
+	return &sdkRenderer{}
+}
+
+type sdkRenderer struct{}
+
+func (r *sdkRenderer) Version(_ context.Context) (string, error) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "", fmt.Errorf("failed to read build info")
+	}
+
+	for _, m := range info.Deps {
+		if m.Path == "helm.sh/helm/v3" {
+			if m.Replace != nil {
+				m = m.Replace
+			}
+
+			return RendererSDK + "/" + m.Version, nil
+		}
+	}
+
+	return "", fmt.Errorf("helm module not found in build info")
+}
+
+// Render renders the chart, helm sdk doesn't support cancellation, ctx is only checked
+// before rendering
+func (r *sdkRenderer) Render(ctx context.Context, req *RenderRequest, out io.Writer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	log := req.Log
+	if log == nil {
+		log = os.Stdout
+	}
+
+	chart, err := loader.Load(req.ChartDir)
+	if err != nil {
+		return fmt.Errorf("failed to load chart %q: %w", req.ChartDir, err)
+	}
+
+	values := make(map[string]interface{})
+	for _, f := range req.ValuesFiles {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return fmt.Errorf("failed to read values file %q: %w", f, err)
+		}
+
+		fileValues := make(map[string]interface{})
+		err = yaml.Unmarshal(data, &fileValues)
+		if err != nil {
+			return fmt.Errorf("failed to parse values file %q: %w", f, err)
+		}
+
+		values = mergeMaps(values, fileValues)
+	}
+
+	values = mergeMaps(values, req.Values)
+	for _, s := range req.Set {
+		err = strvals.ParseInto(s, values)
+		if err != nil {
+			return fmt.Errorf("failed to parse set %q: %w", s, err)
+		}
+	}
+
+	caps, err := sdkCapabilities(req)
+	if err != nil {
+		return err
+	}
+
+	// do not use client only mode, it modifies chartutil.DefaultCapabilities shared by
+	// concurrent renders, set up fake cluster in the same way instead
+	mem := driver.NewMemory()
+	mem.SetNamespace(req.Namespace)
+	cfg := &action.Configuration{
+		Releases:     storage.Init(mem),
+		KubeClient:   &kubefake.PrintingKubeClient{Out: ioutil.Discard},
+		Capabilities: caps,
+		Log: func(format string, v ...interface{}) {
+			_, _ = fmt.Fprintf(log, format+"\n", v...)
+		},
+	}
+
+	client := action.NewInstall(cfg)
+	client.DryRun = true
+	client.Replace = true
+	client.DisableHooks = true
+	client.IncludeCRDs = req.IncludeCRDs
+	client.ReleaseName = req.ReleaseName
+	client.Namespace = req.Namespace
+
+	rel, err := client.Run(chart, values)
+	if err != nil {
+		return fmt.Errorf("failed to render chart %q: %w", req.ChartDir, err)
+	}
+
+	// same output as `helm template`
+	_, err = fmt.Fprintln(out, strings.TrimSpace(rel.Manifest))
+	if err != nil {
+		return fmt.Errorf("failed to write manifests: %w", err)
+	}
+
+	if !req.Hooks {
+		return nil
+	}
+
+	for _, h := range rel.Hooks {
+		_, err = fmt.Fprintf(out, "---\n# Source: %s\n%s\n", h.Path, h.Manifest)
+		if err != nil {
+			return fmt.Errorf("failed to write hook manifests: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// sdkCapabilities returns default capabilities of `helm template` with kube version and
+// api versions in the request
+func sdkCapabilities(req *RenderRequest) (*chartutil.Capabilities, error) {
+	caps := *chartutil.DefaultCapabilities
+	caps.APIVersions = append(append(chartutil.VersionSet{}, caps.APIVersions...), req.APIVersions...)
+
+	if req.KubeVersion == "" {
+		return &caps, nil
+	}
+
+	v, err := semver.NewVersion(req.KubeVersion)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kube version %q: %w", req.KubeVersion, err)
+	}
+
+	caps.KubeVersion = chartutil.KubeVersion{
+		Version: "v" + v.String(),
+		Major:   strconv.FormatUint(v.Major(), 10),
+		Minor:   strconv.FormatUint(v.Minor(), 10),
+	}
+
+	return &caps, nil
+}
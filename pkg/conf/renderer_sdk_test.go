@@ -0,0 +1,133 @@
+package conf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSDKRenderer_Render(t *testing.T) {
+	chartDir := filepath.Join("..", "..", "testdata", "charts", "foo", "latest")
+	req := &RenderRequest{
+		Namespace:   "testing",
+		ReleaseName: "foo",
+		ChartDir:    chartDir,
+		ValuesFiles: []string{filepath.Join(chartDir, "values-bar.yaml")},
+		Values: map[string]interface{}{
+			"foobar": map[string]interface{}{"foobar": "values"},
+		},
+		Set:         []string{"foo.foo=set"},
+		IncludeCRDs: true,
+		Hooks:       true,
+		APIVersions: []string{"example.com/v1"},
+		Log:         ioutil.Discard,
+	}
+
+	sdkOutput := new(bytes.Buffer)
+	if !assert.NoError(t, NewSDKRenderer().Render(context.TODO(), req, sdkOutput)) {
+		return
+	}
+
+	assert.Contains(t, sdkOutput.String(), "foo: set\n")
+	assert.Contains(t, sdkOutput.String(), "foobar: values\n")
+
+	t.Run("Same As Exec", func(t *testing.T) {
+		if _, err := exec.LookPath("helm"); err != nil {
+			t.Skip("helm not found")
+		}
+
+		execOutput := new(bytes.Buffer)
+		if !assert.NoError(t, NewExecRenderer().Render(context.TODO(), req, execOutput)) {
+			return
+		}
+
+		assert.Equal(t, execOutput.String(), sdkOutput.String())
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.TODO())
+		cancel()
+
+		err := NewSDKRenderer().Render(ctx, req, new(bytes.Buffer))
+		assert.True(t, errors.Is(err, context.Canceled))
+	})
+}
+
+func TestSDKRenderer_ExpectedManifests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-stack-test-*")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	var (
+		chartsDir      = filepath.Join(dir, "charts")
+		localChartsDir = filepath.Join("..", "..", "testdata", "charts")
+		envDir         = filepath.Join(dir, "envs")
+	)
+
+	// same as environment `bar` in .helm-stack.yaml
+	charts := map[string]*ChartSpec{
+		"foo@latest": {
+			Name:        "foo@latest",
+			ChartSource: &ChartSource{Local: &ChartFromLocalPath{}},
+		},
+	}
+	e := Environment{
+		Name: "bar",
+		Deployments: []DeploymentSpec{
+			{Name: "testing/foo", Chart: "foo@latest", BaseValues: "values-bar.yaml"},
+		},
+	}
+
+	if !assert.NoError(t, e.Ensure(context.TODO(), false, chartsDir, localChartsDir, envDir, charts)) {
+		return
+	}
+
+	err = e.Gen(context.TODO(), chartsDir, localChartsDir, envDir, charts, GenOptions{Renderer: NewSDKRenderer()})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	actual, err := ioutil.ReadFile(e.ManifestsPath(envDir, &e.Deployments[0]))
+	assert.NoError(t, err)
+
+	expected, err := ioutil.ReadFile(filepath.Join("..", "..", "testdata", "expected-manifests.yaml"))
+	assert.NoError(t, err)
+
+	// expected manifests are the output of `helm template`, namespace is inserted
+	// after the name of every resource
+	expected = regexp.MustCompile(`(?m)^(metadata:\n  name: .*\n)`).
+		ReplaceAll(expected, []byte("${1}  namespace: testing\n"))
+
+	assert.Equal(t, string(expected), string(actual))
+}
+
+func TestSDKCapabilities(t *testing.T) {
+	caps, err := sdkCapabilities(&RenderRequest{KubeVersion: "1.19", APIVersions: []string{"example.com/v1"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, "v1.19.0", caps.KubeVersion.Version)
+	assert.Equal(t, "1", caps.KubeVersion.Major)
+	assert.Equal(t, "19", caps.KubeVersion.Minor)
+	assert.True(t, caps.APIVersions.Has("example.com/v1"))
+	assert.True(t, caps.APIVersions.Has("v1"))
+
+	// defaults are not modified
+	caps, err = sdkCapabilities(&RenderRequest{})
+	assert.NoError(t, err)
+	assert.False(t, caps.APIVersions.Has("example.com/v1"))
+
+	_, err = sdkCapabilities(&RenderRequest{KubeVersion: "latest"})
+	assert.Error(t, err)
+}
@@ -3,6 +3,11 @@ package conf
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 
 	"arhat.dev/pkg/hashhelper"
@@ -51,3 +56,41 @@ func removeEmptyString(s []string) []string {
 
 	return ret
 }
+
+// writeFileAtomic writes file content with write to a temporary file in the same dir and
+// renames it to file on success, the temporary file is removed on failure
+func writeFileAtomic(file string, write func(w io.Writer) error) (err error) {
+	f, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for %q: %w", file, err)
+	}
+
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	err = write(f)
+	if err != nil {
+		return err
+	}
+
+	err = f.Chmod(0644)
+	if err != nil {
+		return fmt.Errorf("failed to set file mode of %q: %w", f.Name(), err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close temporary file %q: %w", f.Name(), err)
+	}
+
+	err = os.Rename(f.Name(), file)
+	if err != nil {
+		return fmt.Errorf("failed to rename %q to %q: %w", f.Name(), file, err)
+	}
+
+	return nil
+}
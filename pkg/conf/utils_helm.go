@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io/ioutil"
 	"strings"
+	"sync"
 
 	"arhat.dev/pkg/exechelper"
 	"github.com/rogpeppe/go-internal/semver"
@@ -41,25 +42,42 @@ func mergeMaps(a, b map[string]interface{}) map[string]interface{} {
 	return out
 }
 
+var helmVersion struct {
+	once    sync.Once
+	version string
+}
+
+// getHelmVersion returns the helm client version (e.g. `v3.3.0+g8a4aeec`), checked only once,
+// empty if failed to check
+func getHelmVersion() string {
+	helmVersion.once.Do(func() {
+		helmVersion.version = checkHelmVersion()
+	})
+
+	return helmVersion.version
+}
+
 func isHelmV2() bool {
+	ver := getHelmVersion()
+	// default to helm3 even when error happened
+	return ver != "" && semver.Compare(ver, "v3") < 0
+}
+
+func checkHelmVersion() string {
 	buf := new(bytes.Buffer)
 	proc, err := exechelper.Do(exechelper.Spec{
 		Command: []string{"helm", "version", "--client", "--short"},
 		Stdout:  buf,
 		Stderr:  ioutil.Discard,
 	})
-
-	// default to helm3 even when error happened
-
 	if err != nil {
-		return false
+		return ""
 	}
-	_, err = proc.Wait()
 
+	_, err = proc.Wait()
 	if err != nil {
-		return false
+		return ""
 	}
 
-	ver := strings.TrimSpace(strings.TrimPrefix(buf.String(), "Client:"))
-	return semver.Compare(ver, "v3") < 0
+	return strings.TrimSpace(strings.TrimPrefix(buf.String(), "Client:"))
 }
@@ -0,0 +1,26 @@
+package conf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestFiles writes files (path relative to dir -> content) for tests
+func writeTestFiles(t *testing.T, dir string, files map[string]string) bool {
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		if !assert.NoError(t, os.MkdirAll(filepath.Dir(file), 0755)) {
+			return false
+		}
+
+		if !assert.NoError(t, ioutil.WriteFile(file, []byte(content), 0644)) {
+			return false
+		}
+	}
+
+	return true
+}
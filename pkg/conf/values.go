@@ -0,0 +1,368 @@
+package conf
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.uber.org/multierr"
+	"sigs.k8s.io/yaml"
+
+	"arhat.dev/helm-stack/pkg/constant"
+)
+
+// deploymentValues are values merged from user values files and sub chart base values
+type deploymentValues struct {
+	// Values to be passed to helm along with chart base values
+	Values map[string]interface{}
+
+	// sources are user values merged into Values, in the order of merge
+	sources []valuesSource
+
+	// subCharts are the chart and its enabled sub charts
+	subCharts []SubChart
+}
+
+// valuesSource is a user values file
+type valuesSource struct {
+	file string
+	// prefix is the key path these values applied to in the merged values
+	prefix []string
+	values map[string]interface{}
+}
+
+// sourceOf finds the user values file defining the value at key path,
+// later merged file wins
+func (v *deploymentValues) sourceOf(path []string) string {
+	for i := len(v.sources) - 1; i >= 0; i-- {
+		src := v.sources[i]
+		if len(path) < len(src.prefix) {
+			continue
+		}
+
+		matched := true
+		for j, p := range src.prefix {
+			if path[j] != p {
+				matched = false
+				break
+			}
+		}
+
+		if matched && hasValuesPath(src.values, path[len(src.prefix):]) {
+			return src.file
+		}
+	}
+
+	return "chart values"
+}
+
+// nolint:gocyclo
+func (e Environment) resolveValues(
+	chartsDir, localChartsDir, envDir string,
+	d DeploymentSpec,
+	chart *ChartSpec,
+) (*deploymentValues, error) {
+	subCharts, err := e.EnabledSubCharts(chartsDir, localChartsDir, envDir, d, chart)
+	if err != nil {
+		return nil, err
+	}
+
+	baseValuesFile := d.BaseValues
+	if baseValuesFile == "" {
+		baseValuesFile = constant.DefaultValuesFile
+	}
+
+	globalFileValues, err := e.readGlobalValuesFile(envDir)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &deploymentValues{subCharts: subCharts}
+	if len(globalFileValues) != 0 {
+		ret.sources = append(ret.sources, valuesSource{
+			file:   e.GlobalValuesFilePath(envDir),
+			prefix: []string{"global"},
+			values: globalFileValues,
+		})
+	}
+	if len(e.GlobalValues) != 0 {
+		ret.sources = append(ret.sources, valuesSource{
+			file:   fmt.Sprintf("globalValues of environment %q", e.Name),
+			prefix: []string{"global"},
+			values: e.GlobalValues,
+		})
+	}
+
+	allValues := globalValuesOverlay(mergeMaps(globalFileValues, e.GlobalValues))
+	for _, sub := range subCharts {
+		subChartName := sub.Name
+		currentValues := map[string]interface{}{}
+
+		subChartValuesFiles := []string{baseValuesFile}
+		if subChartName != "" && baseValuesFile != constant.DefaultValuesFile {
+			// fallback to values.yaml
+			subChartValuesFiles = append(subChartValuesFiles, constant.DefaultValuesFile)
+		}
+
+		valuesFile, data, fErr := e.readValuesFile(envDir, d, subChartName)
+		if fErr != nil {
+			if subChartName == "" || !os.IsNotExist(fErr) {
+				return nil, fmt.Errorf("failed to read values from file %q: %w", valuesFile, fErr)
+			}
+
+			// some sub chart may not contain any values file, check if has values in the chart
+			for _, f := range subChartValuesFiles {
+				_, fErr = sub.ReadFile(f)
+				if fErr == nil {
+					return nil, fmt.Errorf("inconsistent values file, please run `helm-stack ensure` to fix it")
+				}
+			}
+
+			continue
+		}
+
+		if mErr := yaml.Unmarshal(data, &currentValues); mErr != nil {
+			return nil, fmt.Errorf("failed to parse values from file %q: %w", valuesFile, mErr)
+		}
+
+		if subChartName != "" {
+			// get sub chart base values
+			for _, subChartBaseValuesFile := range subChartValuesFiles {
+				data, fErr = sub.ReadFile(subChartBaseValuesFile)
+				// ignore this error
+				if fErr != nil {
+					if !os.IsNotExist(fErr) {
+						return nil, fmt.Errorf(
+							"failed to check sub chart base values %q: %w",
+							sub.Path(subChartBaseValuesFile), fErr,
+						)
+					}
+
+					continue
+				}
+
+				subChartBaseValues := make(map[string]interface{})
+				if mErr := yaml.Unmarshal(data, &subChartBaseValues); mErr != nil {
+					return nil, fmt.Errorf(
+						"failed to parse sub chart base values from file %q: %w",
+						valuesFile, mErr,
+					)
+				}
+
+				switch t := allValues[subChartName].(type) {
+				case map[string]interface{}:
+					allValues[subChartName] = mergeMaps(t, subChartBaseValues)
+				case nil:
+					allValues[subChartName] = subChartBaseValues
+				default:
+					return nil, fmt.Errorf("invalid sub chart values in main values file: %v", t)
+				}
+			}
+		}
+
+		if subChartName == "" {
+			allValues = mergeMaps(allValues, currentValues)
+			ret.sources = append(ret.sources, valuesSource{file: valuesFile, values: currentValues})
+		} else {
+			// merge sub chart values
+			switch t := allValues[subChartName].(type) {
+			case map[string]interface{}:
+				allValues[subChartName] = mergeMaps(t, currentValues)
+			case nil:
+				allValues[subChartName] = currentValues
+			default:
+				return nil, fmt.Errorf("invalid sub chart values in main values file: %v", t)
+			}
+
+			ret.sources = append(ret.sources, valuesSource{
+				file:   valuesFile,
+				prefix: []string{subChartName},
+				values: currentValues,
+			})
+		}
+	}
+
+	if len(d.Set)+len(d.SetString)+len(d.SetFile) != 0 {
+		ops, err := e.resolveSetValues(envDir, d)
+		if err != nil {
+			return nil, err
+		}
+
+		overlay, err := applySetValues(make(map[string]interface{}), ops)
+		if err != nil {
+			return nil, err
+		}
+
+		allValues, err = applySetValues(allValues, ops)
+		if err != nil {
+			return nil, err
+		}
+
+		ret.sources = append(ret.sources, valuesSource{
+			file:   fmt.Sprintf("set of deployment %q", d.Name),
+			values: overlay,
+		})
+	}
+
+	ret.Values = allValues
+	return ret, nil
+}
+
+// ValidateValues validates values of all deployments against values schema of their charts
+func (e Environment) ValidateValues(
+	chartsDir, localChartsDir, envDir string,
+	charts map[string]*ChartSpec,
+) error {
+	var err error
+	for _, d := range e.Deployments {
+		chart := charts[d.Chart]
+		if chart == nil {
+			return fmt.Errorf("chart %s not found", d.Chart)
+		}
+
+		dv, rErr := e.resolveValues(chartsDir, localChartsDir, envDir, d, chart)
+		if rErr != nil {
+			return rErr
+		}
+
+		// refs are not resolved
+		vErr := e.validateValues(chartsDir, localChartsDir, d, chart, dv, dv.Values, true)
+		if vErr != nil {
+			err = multierr.Append(err, fmt.Errorf("invalid values for deployment %q: %w", d.Name, vErr))
+		}
+	}
+
+	return err
+}
+
+// validateValues validates values of the deployment (dv.Values with refs resolved) against
+// `values.schema.json` in the chart and its sub charts, violations at keys with refs are
+// ignored when refs are not resolved (e.g. `gen --no-secrets`)
+func (e Environment) validateValues(
+	chartsDir, localChartsDir string,
+	d DeploymentSpec,
+	chart *ChartSpec,
+	dv *deploymentValues,
+	values map[string]interface{},
+	ignoreRefs bool,
+) error {
+	baseValuesFile := d.BaseValues
+	if baseValuesFile == "" {
+		baseValuesFile = constant.DefaultValuesFile
+	}
+
+	parentValues, err := dv.subCharts[0].readValues(constant.DefaultValuesFile, baseValuesFile)
+	if err != nil {
+		return err
+	}
+	parentValues = mergeMaps(parentValues, values)
+
+	var result error
+	for _, sub := range dv.subCharts {
+		subChartName := sub.Name
+		schemaFile := sub.Path(constant.ValuesSchemaFile)
+		data, err := sub.ReadFile(constant.ValuesSchemaFile)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return fmt.Errorf("failed to read values schema %q: %w", schemaFile, err)
+		}
+
+		var (
+			chartValues = parentValues
+			prefix      []string
+		)
+
+		if subChartName != "" {
+			prefix = []string{subChartName}
+
+			chartValues, err = sub.readValues(constant.DefaultValuesFile)
+			if err != nil {
+				return err
+			}
+
+			if subValues, ok := parentValues[subChartName].(map[string]interface{}); ok {
+				chartValues = mergeMaps(chartValues, subValues)
+			}
+
+			if globalValues, ok := parentValues["global"].(map[string]interface{}); ok {
+				subGlobalValues, _ := chartValues["global"].(map[string]interface{})
+				chartValues["global"] = mergeMaps(subGlobalValues, globalValues)
+			}
+		}
+
+		vErrs, err := validateValuesSchema(data, chartValues)
+		if err != nil {
+			return fmt.Errorf("failed to validate values with schema %q: %w", schemaFile, err)
+		}
+
+		for _, vErr := range vErrs {
+			vErr.Path = append(append([]string{}, prefix...), vErr.Path...)
+			if ignoreRefs && isValuesRefAt(dv.Values, vErr.Path) {
+				continue
+			}
+
+			result = multierr.Append(result, fmt.Errorf("%s: %w", dv.sourceOf(vErr.Path), vErr))
+		}
+	}
+
+	return result
+}
+
+// hasValuesPath checks whether the key path exists in values, list index is
+// represented as `[i]`
+func hasValuesPath(values interface{}, path []string) bool {
+	_, ok := valueAt(values, path)
+	return ok
+}
+
+// isValuesRefAt checks whether the value at key path is a values ref
+func isValuesRefAt(values interface{}, path []string) bool {
+	v, _ := valueAt(values, path)
+	s, ok := v.(string)
+	return ok && strings.HasPrefix(s, valuesRefPrefix)
+}
+
+func valueAt(values interface{}, path []string) (interface{}, bool) {
+	current := values
+	for _, p := range path {
+		switch t := current.(type) {
+		case map[string]interface{}:
+			v, ok := t[p]
+			if !ok {
+				return nil, false
+			}
+			current = v
+		case []interface{}:
+			if !strings.HasPrefix(p, "[") || !strings.HasSuffix(p, "]") {
+				return nil, false
+			}
+
+			idx, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(p, "["), "]"))
+			if err != nil || idx < 0 || idx >= len(t) {
+				return nil, false
+			}
+			current = t[idx]
+		default:
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+// formatValuesPath formats key path as `a.b[0].c`
+func formatValuesPath(path []string) string {
+	buf := new(strings.Builder)
+	for i, p := range path {
+		if i != 0 && !strings.HasPrefix(p, "[") {
+			buf.WriteString(".")
+		}
+		buf.WriteString(p)
+	}
+
+	return buf.String()
+}
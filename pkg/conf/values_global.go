@@ -0,0 +1,64 @@
+package conf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"sigs.k8s.io/yaml"
+)
+
+// GlobalValuesFilePath returns the path of the environment global values file, empty if not set
+func (e Environment) GlobalValuesFilePath(envDir string) string {
+	if e.GlobalValuesFile == "" {
+		return ""
+	}
+
+	if filepath.IsAbs(e.GlobalValuesFile) {
+		return e.GlobalValuesFile
+	}
+
+	return filepath.Join(e.ValuesDir(envDir), e.GlobalValuesFile)
+}
+
+// readGlobalValuesFile reads environment global values file (the content of `global` key),
+// returns empty values if not set
+func (e Environment) readGlobalValuesFile(envDir string) (map[string]interface{}, error) {
+	ret := make(map[string]interface{})
+
+	file := e.GlobalValuesFilePath(envDir)
+	if file == "" {
+		return ret, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read global values file %q: %w", file, err)
+	}
+
+	err = yaml.Unmarshal(data, &ret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse global values file %q: %w", file, err)
+	}
+
+	return ret, nil
+}
+
+// resolveGlobalValues merges inline global values over global values file
+func (e Environment) resolveGlobalValues(envDir string) (map[string]interface{}, error) {
+	fileValues, err := e.readGlobalValuesFile(envDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeMaps(fileValues, e.GlobalValues), nil
+}
+
+// globalValuesOverlay wraps global values as values to be merged over chart values
+func globalValuesOverlay(globalValues map[string]interface{}) map[string]interface{} {
+	if len(globalValues) == 0 {
+		return map[string]interface{}{}
+	}
+
+	return map[string]interface{}{"global": globalValues}
+}
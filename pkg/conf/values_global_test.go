@@ -0,0 +1,95 @@
+package conf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvironment_GlobalValues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-stack-test-*")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	var (
+		chartsDir      = filepath.Join(dir, "charts")
+		localChartsDir = filepath.Join(dir, "local-charts")
+		envDir         = filepath.Join(dir, "envs")
+	)
+
+	chart := &ChartSpec{
+		Name:        "foo@latest",
+		ChartSource: &ChartSource{Local: &ChartFromLocalPath{}},
+	}
+	d := DeploymentSpec{Name: "default/foo", Chart: "foo@latest"}
+	e := Environment{
+		Name:             "test",
+		GlobalValuesFile: "global.yaml",
+		GlobalValues: map[string]interface{}{
+			"registry": "inline.example.com",
+			"labels":   map[string]interface{}{"team": "inline"},
+		},
+		Deployments: []DeploymentSpec{d},
+	}
+
+	if !writeTestFiles(t, dir, map[string]string{
+		"local-charts/foo/latest/Chart.yaml":  "name: foo\n",
+		"local-charts/foo/latest/values.yaml": "global: {}\n",
+		"envs/test/global.yaml": `
+registry: file.example.com
+pullPolicy: Always
+storageClass: standard
+labels:
+  team: file
+  env: test
+`,
+		filepath.Join("envs", "test", d.Filename("")): `
+global:
+  storageClass: fast
+`,
+	}) {
+		return
+	}
+
+	assert.Equal(t, filepath.Join(envDir, "test", "global.yaml"), e.GlobalValuesFilePath(envDir))
+
+	dv, err := e.resolveValues(chartsDir, localChartsDir, envDir, d, chart)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// global values file < inline global values < deployment values
+	assert.EqualValues(t, map[string]interface{}{
+		"registry":     "inline.example.com",
+		"pullPolicy":   "Always",
+		"storageClass": "fast",
+		"labels":       map[string]interface{}{"team": "inline", "env": "test"},
+	}, dv.Values["global"])
+
+	assert.Equal(t, e.GlobalValuesFilePath(envDir), dv.sourceOf([]string{"global", "pullPolicy"}))
+	assert.Equal(t, `globalValues of environment "test"`, dv.sourceOf([]string{"global", "registry"}))
+	assert.Equal(t, filepath.Join(envDir, "test", d.Filename("")), dv.sourceOf([]string{"global", "storageClass"}))
+
+	t.Run("Absolute Path", func(t *testing.T) {
+		e := Environment{Name: "test", GlobalValuesFile: filepath.Join(dir, "global.yaml")}
+		assert.Equal(t, filepath.Join(dir, "global.yaml"), e.GlobalValuesFilePath(envDir))
+
+		_, err := e.resolveGlobalValues(envDir)
+		assert.Error(t, err)
+	})
+
+	t.Run("Not Set", func(t *testing.T) {
+		e := Environment{Name: "test"}
+		assert.Equal(t, "", e.GlobalValuesFilePath(envDir))
+
+		values, err := e.resolveGlobalValues(envDir)
+		assert.NoError(t, err)
+		assert.Len(t, values, 0)
+		assert.EqualValues(t, map[string]interface{}{}, globalValuesOverlay(values))
+	})
+}
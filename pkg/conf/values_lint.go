@@ -0,0 +1,159 @@
+package conf
+
+import (
+	"fmt"
+	"sort"
+
+	"go.uber.org/multierr"
+
+	"arhat.dev/helm-stack/pkg/constant"
+)
+
+// defaultFreeFormValues are keys of values commonly used as free-form maps in charts
+var defaultFreeFormValues = []string{
+	"global",
+	"annotations",
+	"labels",
+	"podAnnotations",
+	"podLabels",
+	"serviceAnnotations",
+	"nodeSelector",
+	"affinity",
+	"tolerations",
+	"resources",
+	"securityContext",
+	"podSecurityContext",
+	"env",
+	"extraEnv",
+	"extraEnvVars",
+	"extraArgs",
+}
+
+// LintValues checks keys in user values files of all deployments, report keys not
+// defined in chart default values
+func (e Environment) LintValues(
+	chartsDir, localChartsDir, envDir string,
+	charts map[string]*ChartSpec,
+) error {
+	var err error
+	for _, d := range e.Deployments {
+		chart := charts[d.Chart]
+		if chart == nil {
+			return fmt.Errorf("chart %s not found", d.Chart)
+		}
+
+		dv, rErr := e.resolveValues(chartsDir, localChartsDir, envDir, d, chart)
+		if rErr != nil {
+			return rErr
+		}
+
+		lErr := e.lintValues(chartsDir, localChartsDir, d, chart, dv)
+		if lErr != nil {
+			err = multierr.Append(err, fmt.Errorf("unknown values for deployment %q: %w", d.Name, lErr))
+		}
+	}
+
+	return err
+}
+
+func (e Environment) lintValues(
+	chartsDir, localChartsDir string,
+	d DeploymentSpec,
+	chart *ChartSpec,
+	dv *deploymentValues,
+) error {
+	baseValuesFile := d.BaseValues
+	if baseValuesFile == "" {
+		baseValuesFile = constant.DefaultValuesFile
+	}
+
+	// collect all known values keys
+	reference, err := dv.subCharts[0].readValues(constant.DefaultValuesFile, baseValuesFile)
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range dv.subCharts[1:] {
+		subReference, err := sub.readValues(constant.DefaultValuesFile, baseValuesFile)
+		if err != nil {
+			return err
+		}
+
+		if parentReference, ok := reference[sub.Name].(map[string]interface{}); ok {
+			subReference = mergeMaps(subReference, parentReference)
+		}
+
+		reference[sub.Name] = subReference
+	}
+
+	freeForm := make(map[string]struct{})
+	for _, k := range append(append([]string{}, defaultFreeFormValues...), d.FreeFormValues...) {
+		freeForm[k] = struct{}{}
+	}
+
+	isFreeForm := func(path []string) bool {
+		if _, ok := freeForm[path[len(path)-1]]; ok {
+			return true
+		}
+
+		_, ok := freeForm[formatValuesPath(path)]
+		return ok
+	}
+
+	var result error
+	for _, src := range dv.sources {
+		if len(src.prefix) != 0 && isFreeForm(src.prefix) {
+			// e.g. environment global values
+			continue
+		}
+
+		ref := reference
+		for _, p := range src.prefix {
+			ref, _ = ref[p].(map[string]interface{})
+		}
+
+		for _, path := range findUnknownValuesKeys(ref, src.values, src.prefix, isFreeForm) {
+			result = multierr.Append(result, fmt.Errorf("%s: unknown key %q", src.file, formatValuesPath(path)))
+		}
+	}
+
+	return result
+}
+
+// findUnknownValuesKeys walks values and returns key paths not found in reference values,
+// empty maps in reference values are treated as free-form
+func findUnknownValuesKeys(
+	reference, values map[string]interface{},
+	prefix []string,
+	isFreeForm func(path []string) bool,
+) [][]string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var ret [][]string
+	for _, k := range keys {
+		path := append(append([]string{}, prefix...), k)
+
+		refV, ok := reference[k]
+		if !ok {
+			if !isFreeForm(path) {
+				ret = append(ret, path)
+			}
+
+			continue
+		}
+
+		v, isMap := values[k].(map[string]interface{})
+		refMap, refIsMap := refV.(map[string]interface{})
+		if !isMap || !refIsMap || len(refMap) == 0 || isFreeForm(path) {
+			continue
+		}
+
+		ret = append(ret, findUnknownValuesKeys(refMap, v, path, isFreeForm)...)
+	}
+
+	return ret
+}
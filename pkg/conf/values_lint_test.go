@@ -0,0 +1,141 @@
+package conf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/multierr"
+)
+
+func TestFindUnknownValuesKeys(t *testing.T) {
+	reference := map[string]interface{}{
+		"image": map[string]interface{}{
+			"repo": "nginx",
+			"tag":  "",
+		},
+		"extra":          map[string]interface{}{},
+		"config":         map[string]interface{}{"a": 1},
+		"podAnnotations": map[string]interface{}{"a": "b"},
+	}
+
+	freeForm := map[string]bool{"podAnnotations": true, "config.data": true}
+	isFreeForm := func(path []string) bool {
+		return freeForm[path[len(path)-1]] || freeForm[formatValuesPath(path)]
+	}
+
+	tests := []struct {
+		name     string
+		values   map[string]interface{}
+		prefix   []string
+		expected [][]string
+	}{
+		{
+			name:   "Known",
+			values: map[string]interface{}{"image": map[string]interface{}{"tag": "1.19"}},
+		},
+		{
+			name: "Unknown",
+			values: map[string]interface{}{
+				"image": map[string]interface{}{"tg": "1.19", "repo": "nginx"},
+				"foo":   true,
+			},
+			expected: [][]string{{"foo"}, {"image", "tg"}},
+		},
+		{
+			name: "Empty Reference Map",
+			values: map[string]interface{}{
+				"extra": map[string]interface{}{"foo": "bar"},
+			},
+		},
+		{
+			name: "Free Form",
+			values: map[string]interface{}{
+				"podAnnotations": map[string]interface{}{"foo": "bar"},
+				"config":         map[string]interface{}{"data": map[string]interface{}{"foo": "bar"}},
+			},
+		},
+		{
+			name:     "Prefix",
+			values:   map[string]interface{}{"foo": "bar"},
+			prefix:   []string{"sub"},
+			expected: [][]string{{"sub", "foo"}},
+		},
+		{
+			name:   "Scalar Overrides Map",
+			values: map[string]interface{}{"image": "nginx:1.19"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.EqualValues(t, test.expected, findUnknownValuesKeys(reference, test.values, test.prefix, isFreeForm))
+		})
+	}
+}
+
+func TestEnvironment_LintValues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-stack-test-*")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	var (
+		localChartsDir = filepath.Join(dir, "local-charts")
+		envDir         = filepath.Join(dir, "envs")
+	)
+
+	charts := map[string]*ChartSpec{
+		"foo@latest": {
+			Name:        "foo@latest",
+			ChartSource: &ChartSource{Local: &ChartFromLocalPath{}},
+		},
+	}
+	e := Environment{
+		Name: "test",
+		Deployments: []DeploymentSpec{{
+			Name:           "default/foo",
+			Chart:          "foo@latest",
+			FreeFormValues: []string{"config"},
+		}},
+	}
+
+	if !writeTestFiles(t, dir, map[string]string{
+		"local-charts/foo/latest/Chart.yaml": "name: foo\n",
+		"local-charts/foo/latest/values.yaml": `
+image:
+  repo: nginx
+  tag: ""
+config:
+  a: 1
+`,
+		filepath.Join("envs", "test", e.Deployments[0].Filename("")): `
+image:
+  tg: "1.19"
+config:
+  b: 2
+podAnnotations:
+  foo: bar
+unknown: true
+`,
+	}) {
+		return
+	}
+
+	err = e.LintValues(filepath.Join(dir, "charts"), localChartsDir, envDir, charts)
+	if !assert.Error(t, err) {
+		return
+	}
+
+	errs := multierr.Errors(err)
+	if !assert.Len(t, errs, 1) {
+		return
+	}
+
+	valuesFile := filepath.Join(envDir, "test", e.Deployments[0].Filename(""))
+	assert.EqualError(t, errs[0], `unknown values for deployment "default/foo": `+
+		valuesFile+`: unknown key "image.tg"; `+valuesFile+`: unknown key "unknown"`)
+}
@@ -0,0 +1,264 @@
+package conf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"arhat.dev/pkg/exechelper"
+)
+
+const (
+	valuesRefPrefix = "ref+"
+)
+
+// RefResolver resolves values references in the format of `ref+<scheme>://<ref>`
+type RefResolver interface {
+	// Resolve the ref (without the `ref+<scheme>://` prefix)
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+// RefResolverFunc is a function implementing RefResolver
+type RefResolverFunc func(ctx context.Context, ref string) (string, error)
+
+func (f RefResolverFunc) Resolve(ctx context.Context, ref string) (string, error) {
+	return f(ctx, ref)
+}
+
+// NewValuesRefResolver creates a resolver with builtin `env`, `file` and `exec` ref resolvers,
+// resolved values are cached for its whole lifetime, the same ref is resolved only once even
+// when requested concurrently
+//
+// when noSecrets is set, references are replaced with placeholders instead of resolving
+func NewValuesRefResolver(noSecrets bool) *ValuesRefResolver {
+	return &ValuesRefResolver{
+		noSecrets: noSecrets,
+		resolvers: map[string]RefResolver{
+			"env":  RefResolverFunc(resolveEnvRef),
+			"file": RefResolverFunc(resolveFileRef),
+			"exec": RefResolverFunc(resolveExecRef),
+		},
+		cache: make(map[string]*refCall),
+		mu:    new(sync.Mutex),
+	}
+}
+
+type ValuesRefResolver struct {
+	noSecrets bool
+	resolvers map[string]RefResolver
+
+	// cache of resolved and in-flight refs, failed refs are removed
+	cache map[string]*refCall
+	mu    *sync.Mutex
+}
+
+type refCall struct {
+	done  chan struct{}
+	value string
+	err   error
+}
+
+// Register a resolver for refs with scheme, existing resolver of the same scheme is replaced
+func (r *ValuesRefResolver) Register(scheme string, resolver RefResolver) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.resolvers[scheme] = resolver
+}
+
+// ResolveValues replaces all string values referencing secrets with resolved values, relative
+// paths of `file` refs are resolved relative to valuesDir
+func (r *ValuesRefResolver) ResolveValues(
+	ctx context.Context,
+	valuesDir string,
+	values map[string]interface{},
+) (map[string]interface{}, error) {
+	ret, err := r.resolveValue(ctx, valuesDir, nil, values)
+	if err != nil {
+		return nil, err
+	}
+
+	return ret.(map[string]interface{}), nil
+}
+
+// ResolvesSecrets checks whether values contain refs to be resolved (not replaced with placeholders)
+func (r *ValuesRefResolver) ResolvesSecrets(values map[string]interface{}) bool {
+	return !r.noSecrets && hasValuesRefs(values)
+}
+
+func hasValuesRefs(v interface{}) bool {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for _, v := range t {
+			if hasValuesRefs(v) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, v := range t {
+			if hasValuesRefs(v) {
+				return true
+			}
+		}
+	case string:
+		return strings.HasPrefix(t, valuesRefPrefix)
+	}
+
+	return false
+}
+
+func (r *ValuesRefResolver) resolveValue(
+	ctx context.Context,
+	valuesDir string,
+	path []string,
+	v interface{},
+) (interface{}, error) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		ret := make(map[string]interface{}, len(t))
+		for _, k := range keys {
+			resolved, err := r.resolveValue(ctx, valuesDir, append(append([]string{}, path...), k), t[k])
+			if err != nil {
+				return nil, err
+			}
+
+			ret[k] = resolved
+		}
+
+		return ret, nil
+	case []interface{}:
+		ret := make([]interface{}, len(t))
+		for i := range t {
+			idx := fmt.Sprintf("[%d]", i)
+			resolved, err := r.resolveValue(ctx, valuesDir, append(append([]string{}, path...), idx), t[i])
+			if err != nil {
+				return nil, err
+			}
+
+			ret[i] = resolved
+		}
+
+		return ret, nil
+	case string:
+		if !strings.HasPrefix(t, valuesRefPrefix) {
+			return t, nil
+		}
+
+		resolved, err := r.resolve(ctx, valuesDir, t)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %q: %w", formatValuesPath(path), err)
+		}
+
+		return resolved, nil
+	default:
+		return v, nil
+	}
+}
+
+func (r *ValuesRefResolver) resolve(ctx context.Context, valuesDir, ref string) (string, error) {
+	if r.noSecrets {
+		return "<" + ref + ">", nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(ref, valuesRefPrefix), "://", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid ref %q, expecting ref+<scheme>://<ref>", ref)
+	}
+
+	scheme, target := parts[0], parts[1]
+	if scheme == "file" && !filepath.IsAbs(target) {
+		target = filepath.Join(valuesDir, target)
+	}
+
+	// relative file refs in different values dirs are different refs
+	key := scheme + "://" + target
+
+	r.mu.Lock()
+	call, ok := r.cache[key]
+	if ok {
+		r.mu.Unlock()
+
+		select {
+		case <-call.done:
+			return call.value, call.err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+
+	resolver, ok := r.resolvers[scheme]
+	if !ok {
+		r.mu.Unlock()
+		return "", fmt.Errorf("unsupported ref scheme %q", scheme)
+	}
+
+	call = &refCall{done: make(chan struct{})}
+	r.cache[key] = call
+	r.mu.Unlock()
+
+	call.value, call.err = resolver.Resolve(ctx, target)
+	if call.err != nil {
+		// allow retry
+		r.mu.Lock()
+		delete(r.cache, key)
+		r.mu.Unlock()
+	}
+
+	close(call.done)
+	return call.value, call.err
+}
+
+func resolveEnvRef(_ context.Context, name string) (string, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("env %q not set", name)
+	}
+
+	return v, nil
+}
+
+func resolveFileRef(_ context.Context, file string) (string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
+
+func resolveExecRef(ctx context.Context, cmd string) (string, error) {
+	command := strings.Fields(cmd)
+	if len(command) == 0 {
+		return "", fmt.Errorf("invalid empty command")
+	}
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	proc, err := exechelper.Do(exechelper.Spec{
+		Context: ctx,
+		Command: command,
+		Stdout:  stdout,
+		Stderr:  stderr,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to execute command: %w", err)
+	}
+
+	_, err = proc.Wait()
+	if err != nil {
+		return "", fmt.Errorf("failed to run command %q: %w: %s", command[0], err, stderr.String())
+	}
+
+	return strings.TrimSuffix(stdout.String(), "\n"), nil
+}
@@ -0,0 +1,181 @@
+package conf
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValuesRefResolver_ResolveValues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-stack-test-*")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	if !assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "password"), []byte("file-secret\n"), 0600)) {
+		return
+	}
+
+	if !assert.NoError(t, os.Setenv("HELM_STACK_TEST_TOKEN", "env-secret")) {
+		return
+	}
+	defer func() { _ = os.Unsetenv("HELM_STACK_TEST_TOKEN") }()
+
+	values := map[string]interface{}{
+		"token":    "ref+env://HELM_STACK_TEST_TOKEN",
+		"password": "ref+file://password",
+		"nested": map[string]interface{}{
+			"list":  []interface{}{"ref+test://foo", "plain", 1},
+			"plain": "env://HELM_STACK_TEST_TOKEN",
+		},
+		"enabled": true,
+	}
+
+	t.Run("Resolve", func(t *testing.T) {
+		calls := 0
+		r := NewValuesRefResolver(false)
+		r.Register("test", RefResolverFunc(func(_ context.Context, ref string) (string, error) {
+			calls++
+			return "test-" + ref, nil
+		}))
+
+		resolved, err := r.ResolveValues(context.TODO(), dir, values)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.EqualValues(t, map[string]interface{}{
+			"token":    "env-secret",
+			"password": "file-secret",
+			"nested": map[string]interface{}{
+				"list":  []interface{}{"test-foo", "plain", 1},
+				"plain": "env://HELM_STACK_TEST_TOKEN",
+			},
+			"enabled": true,
+		}, resolved)
+
+		// original values untouched
+		assert.Equal(t, "ref+env://HELM_STACK_TEST_TOKEN", values["token"])
+
+		// resolved refs are cached
+		_, err = r.ResolveValues(context.TODO(), dir, values)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("No Secrets", func(t *testing.T) {
+		r := NewValuesRefResolver(true)
+		r.Register("test", RefResolverFunc(func(_ context.Context, ref string) (string, error) {
+			t.Errorf("unexpected resolve of %q", ref)
+			return "", nil
+		}))
+
+		resolved, err := r.ResolveValues(context.TODO(), dir, values)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.EqualValues(t, map[string]interface{}{
+			"token":    "<ref+env://HELM_STACK_TEST_TOKEN>",
+			"password": "<ref+file://password>",
+			"nested": map[string]interface{}{
+				"list":  []interface{}{"<ref+test://foo>", "plain", 1},
+				"plain": "env://HELM_STACK_TEST_TOKEN",
+			},
+			"enabled": true,
+		}, resolved)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			values map[string]interface{}
+			err    string
+		}{
+			{
+				name:   "Env Not Set",
+				values: map[string]interface{}{"a": []interface{}{"ref+env://HELM_STACK_TEST_NOT_SET"}},
+				err:    `failed to resolve "a[0]": env "HELM_STACK_TEST_NOT_SET" not set`,
+			},
+			{
+				name:   "Unsupported Scheme",
+				values: map[string]interface{}{"a": map[string]interface{}{"b": "ref+vault://foo"}},
+				err:    `failed to resolve "a.b": unsupported ref scheme "vault"`,
+			},
+			{
+				name:   "Invalid",
+				values: map[string]interface{}{"a": "ref+foo"},
+				err:    `failed to resolve "a": invalid ref "ref+foo", expecting ref+<scheme>://<ref>`,
+			},
+		}
+
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				_, err := NewValuesRefResolver(false).ResolveValues(context.TODO(), dir, test.values)
+				assert.EqualError(t, err, test.err)
+			})
+		}
+	})
+}
+
+func TestValuesRefResolver_Concurrent(t *testing.T) {
+	var (
+		calls   int32
+		release = make(chan struct{})
+	)
+
+	r := NewValuesRefResolver(false)
+	r.Register("slow", RefResolverFunc(func(_ context.Context, ref string) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "slow-" + ref, nil
+	}))
+	r.Register("test", RefResolverFunc(func(_ context.Context, ref string) (string, error) {
+		return "test-" + ref, nil
+	}))
+
+	values := map[string]interface{}{"a": "ref+slow://foo"}
+
+	wg := new(sync.WaitGroup)
+	results := make([]map[string]interface{}, 3)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			resolved, err := r.ResolveValues(context.TODO(), "", values)
+			assert.NoError(t, err)
+			results[i] = resolved
+		}(i)
+	}
+
+	// other refs are not blocked by the slow one
+	resolved, err := r.ResolveValues(context.TODO(), "", map[string]interface{}{"b": "ref+test://bar"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"b": "test-bar"}, resolved)
+
+	close(release)
+	wg.Wait()
+
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+	for _, v := range results {
+		assert.Equal(t, map[string]interface{}{"a": "slow-foo"}, v)
+	}
+}
+
+func TestValuesRefResolver_ResolvesSecrets(t *testing.T) {
+	values := map[string]interface{}{
+		"a": []interface{}{map[string]interface{}{"b": "ref+env://FOO"}},
+	}
+
+	assert.True(t, NewValuesRefResolver(false).ResolvesSecrets(values))
+	assert.False(t, NewValuesRefResolver(true).ResolvesSecrets(values))
+	assert.False(t, NewValuesRefResolver(false).ResolvesSecrets(map[string]interface{}{"a": "env://FOO"}))
+}
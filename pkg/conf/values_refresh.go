@@ -0,0 +1,321 @@
+package conf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// refreshValuesFile adds values keys newly introduced in upstream values file to the existing
+// values file, existing keys, values, comments and formatting are preserved
+//
+// upstream values merged last time are kept in upstreamSnapshotFile, keys existing in the snapshot
+// but missing in the values file were removed by user and are not added again
+//
+// returns key paths added
+func refreshValuesFile(
+	valuesFile, upstreamSnapshotFile, upstreamValuesFile string,
+	upstreamData []byte,
+) ([][]string, error) {
+	data, err := ioutil.ReadFile(valuesFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read values file %q: %w", valuesFile, err)
+	}
+
+	previous, err := ioutil.ReadFile(upstreamSnapshotFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read upstream values snapshot %q: %w", upstreamSnapshotFile, err)
+	}
+
+	result, added, err := mergeValuesDocuments(data, upstreamData, previous)
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge upstream values %q into %q: %w", upstreamValuesFile, valuesFile, err)
+	}
+
+	// do not touch values file when nothing changed
+	if len(added) != 0 {
+		err = writeFileAtomic(valuesFile, func(w io.Writer) error {
+			_, err2 := w.Write(result)
+			return err2
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to update values file %q: %w", valuesFile, err)
+		}
+	}
+
+	if !bytes.Equal(previous, upstreamData) {
+		err = writeUpstreamValuesSnapshot(upstreamSnapshotFile, upstreamData)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return added, nil
+}
+
+func writeUpstreamValuesSnapshot(file string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to ensure upstream values dir: %w", err)
+	}
+
+	err = writeFileAtomic(file, func(w io.Writer) error {
+		_, err2 := w.Write(data)
+		return err2
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write upstream values snapshot %q: %w", file, err)
+	}
+
+	return nil
+}
+
+// mergeValuesDocuments adds yaml nodes missing in current document from upstream document, nodes
+// existing in previous upstream document are considered removed intentionally and are skipped
+//
+// text of new nodes is inserted into current document as is, the document is re-encoded only
+// when it's not possible (e.g. flow style mappings)
+func mergeValuesDocuments(current, upstream, previous []byte) ([]byte, [][]string, error) {
+	currentDoc := new(yaml.Node)
+	err := yaml.Unmarshal(current, currentDoc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse values: %w", err)
+	}
+
+	upstreamDoc := new(yaml.Node)
+	err = yaml.Unmarshal(upstream, upstreamDoc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse upstream values: %w", err)
+	}
+
+	previousDoc := new(yaml.Node)
+	err = yaml.Unmarshal(previous, previousDoc)
+	if err != nil {
+		// upstream values snapshot is not managed by user, just ignore it
+		previousDoc = new(yaml.Node)
+	}
+
+	if len(upstreamDoc.Content) == 0 {
+		// upstream values is empty
+		return current, nil, nil
+	}
+
+	if len(currentDoc.Content) == 0 {
+		// values file intentionally left empty
+		return current, nil, nil
+	}
+
+	currentRoot, upstreamRoot := currentDoc.Content[0], upstreamDoc.Content[0]
+	if currentRoot.Kind != yaml.MappingNode || upstreamRoot.Kind != yaml.MappingNode {
+		return nil, nil, fmt.Errorf("values is not a map")
+	}
+
+	var previousRoot *yaml.Node
+	if len(previousDoc.Content) != 0 && previousDoc.Content[0].Kind == yaml.MappingNode {
+		previousRoot = previousDoc.Content[0]
+	}
+
+	additions := missingValuesNodes(currentRoot, upstreamRoot, previousRoot, nil)
+	if len(additions) == 0 {
+		return current, nil, nil
+	}
+
+	added := make([][]string, len(additions))
+	for i, a := range additions {
+		added[i] = a.path
+	}
+
+	data, ok := insertValuesText(current, upstream, additions)
+	if ok {
+		return data, added, nil
+	}
+
+	for _, a := range additions {
+		a.dst.Content = append(a.dst.Content, a.key, a.value)
+	}
+
+	data, err = encodeYamlNode(currentDoc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return data, added, nil
+}
+
+// valuesAddition is a key/value pair in upstream values missing in current values
+type valuesAddition struct {
+	path []string
+
+	// dst is the mapping node in current values to add the pair
+	dst *yaml.Node
+
+	key, value *yaml.Node
+}
+
+// missingValuesNodes collects key/value pairs only existing in src mapping node but not in dst
+// and prev mapping nodes, child mapping nodes are checked recursively
+func missingValuesNodes(dst, src, prev *yaml.Node, prefix []string) []valuesAddition {
+	var ret []valuesAddition
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		keyNode, valueNode := src.Content[i], src.Content[i+1]
+		path := append(append([]string{}, prefix...), keyNode.Value)
+
+		prevValueNode := mappingValue(prev, keyNode.Value)
+		dstValueNode := mappingValue(dst, keyNode.Value)
+		if dstValueNode == nil {
+			if prevValueNode == nil {
+				ret = append(ret, valuesAddition{path: path, dst: dst, key: keyNode, value: valueNode})
+			}
+
+			continue
+		}
+
+		if dstValueNode.Kind == yaml.MappingNode && valueNode.Kind == yaml.MappingNode {
+			ret = append(ret, missingValuesNodes(dstValueNode, valueNode, prevValueNode, path)...)
+		}
+	}
+
+	return ret
+}
+
+// mappingValue returns value node of key in mapping node n, nil if not found
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+// insertValuesText inserts upstream text of additions after the last entry of their mapping
+// nodes in current values, returns false if any of the mapping nodes can not be edited as text
+func insertValuesText(current, upstream []byte, additions []valuesAddition) ([]byte, bool) {
+	type insertion struct {
+		indent int
+		lines  []string
+	}
+
+	var (
+		currentLines  = strings.Split(string(current), "\n")
+		upstreamLines = strings.Split(string(upstream), "\n")
+
+		// line index in current values to insertions before that line
+		insertions = make(map[int][]insertion)
+	)
+
+	for _, a := range additions {
+		if a.dst.Style&yaml.FlowStyle != 0 || len(a.dst.Content) == 0 || a.key.Style&yaml.FlowStyle != 0 {
+			return nil, false
+		}
+
+		lastKey, lastValue := a.dst.Content[len(a.dst.Content)-2], a.dst.Content[len(a.dst.Content)-1]
+		indent := lastKey.Column - 1
+		at := yamlEntryEnd(currentLines, lastKey.Line-1, indent, lastValue.Kind == yaml.SequenceNode) + 1
+
+		srcIndent := a.key.Column - 1
+		start := a.key.Line - 1
+		for start > 0 && strings.HasPrefix(upstreamLines[start-1], strings.Repeat(" ", srcIndent)+"#") {
+			// head comments
+			start--
+		}
+		end := yamlEntryEnd(upstreamLines, a.key.Line-1, srcIndent, a.value.Kind == yaml.SequenceNode)
+
+		lines, ok := reindentYamlLines(upstreamLines[start:end+1], srcIndent, indent)
+		if !ok {
+			return nil, false
+		}
+
+		insertions[at] = append(insertions[at], insertion{indent: indent, lines: lines})
+	}
+
+	var result []string
+	for i := 0; i <= len(currentLines); i++ {
+		ins := insertions[i]
+		// children of the last entry go before its siblings
+		sort.SliceStable(ins, func(i, j int) bool { return ins[i].indent > ins[j].indent })
+		for _, in := range ins {
+			result = append(result, in.lines...)
+		}
+
+		if i < len(currentLines) {
+			result = append(result, currentLines[i])
+		}
+	}
+
+	return []byte(strings.Join(result, "\n")), true
+}
+
+// yamlEntryEnd returns index of the last line of the mapping entry with key at line index keyLine
+//
+// following lines indented more than the key belong to the entry, so do sequence items at the
+// same indent if the value is a sequence, blank lines and less indented comments are left out
+func yamlEntryEnd(lines []string, keyLine, indent int, sequence bool) int {
+	end := keyLine
+	for i := keyLine + 1; i < len(lines); i++ {
+		content := strings.TrimLeft(lines[i], " ")
+		if strings.TrimSpace(content) == "" {
+			continue
+		}
+
+		lineIndent := len(lines[i]) - len(content)
+		if lineIndent > indent || (sequence && lineIndent == indent && strings.HasPrefix(content, "-")) {
+			end = i
+			continue
+		}
+
+		break
+	}
+
+	return end
+}
+
+// reindentYamlLines changes indent of lines from `from` spaces to `to` spaces, returns false
+// if any non-blank line is indented less than `from`
+func reindentYamlLines(lines []string, from, to int) ([]string, bool) {
+	ret := make([]string, len(lines))
+	for i, l := range lines {
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+
+		if len(l)-len(strings.TrimLeft(l, " ")) < from {
+			return nil, false
+		}
+
+		ret[i] = strings.Repeat(" ", to) + l[from:]
+	}
+
+	return ret, true
+}
+
+func encodeYamlNode(n *yaml.Node) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+
+	err := enc.Encode(n)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode yaml: %w", err)
+	}
+
+	err = enc.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode yaml: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,217 @@
+package conf
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeValuesDocuments(t *testing.T) {
+	const (
+		current = `# head comment
+replicas: 3 # we need more
+
+image:
+  # pinned
+  tag: "v1.0.0"
+
+args:
+- --foo
+`
+		upstream = `replicas: 1
+image:
+  repository: foo
+  tag: latest
+  pullSecrets:
+    - name: bar
+args: []
+# enable metrics
+metrics:
+  enabled: false
+  # text
+  description: |
+    multi-line
+
+    text
+`
+		expected = `# head comment
+replicas: 3 # we need more
+
+image:
+  # pinned
+  tag: "v1.0.0"
+  repository: foo
+  pullSecrets:
+    - name: bar
+
+args:
+- --foo
+# enable metrics
+metrics:
+  enabled: false
+  # text
+  description: |
+    multi-line
+
+    text
+`
+	)
+
+	result, added, err := mergeValuesDocuments([]byte(current), []byte(upstream), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, [][]string{{"image", "repository"}, {"image", "pullSecrets"}, {"metrics"}}, added)
+	assert.Equal(t, expected, string(result))
+
+	result, added, err = mergeValuesDocuments([]byte(expected), []byte(upstream), []byte(upstream))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Len(t, added, 0)
+	assert.Equal(t, expected, string(result))
+}
+
+func TestMergeValuesDocuments_Removed(t *testing.T) {
+	const (
+		current = `image:
+  tag: v1
+`
+		previous = `image:
+  repository: foo
+  tag: latest
+metrics: {}
+`
+		upstream = `image:
+  repository: foo
+  tag: latest
+  pullPolicy: Always
+metrics: {}
+resources: {}
+`
+	)
+
+	result, added, err := mergeValuesDocuments([]byte(current), []byte(upstream), []byte(previous))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, [][]string{{"image", "pullPolicy"}, {"resources"}}, added)
+	assert.Equal(t, "image:\n  tag: v1\n  pullPolicy: Always\nresources: {}\n", string(result))
+}
+
+func TestMergeValuesDocuments_Nested(t *testing.T) {
+	const (
+		current = `a:
+  b:
+    c: 1
+`
+		upstream = `z: 0
+a:
+  y: 2
+  b:
+    x: 3
+`
+	)
+
+	result, _, err := mergeValuesDocuments([]byte(current), []byte(upstream), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// children of the last entry go before its siblings
+	assert.Equal(t, "a:\n  b:\n    c: 1\n    x: 3\n  y: 2\nz: 0\n", string(result))
+}
+
+func TestMergeValuesDocuments_FlowStyle(t *testing.T) {
+	result, added, err := mergeValuesDocuments([]byte("image: {tag: v1}\n"), []byte("image: {repository: foo}\n"), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, [][]string{{"image", "repository"}}, added)
+	assert.Equal(t, "image: {tag: v1, repository: foo}\n", string(result))
+}
+
+func TestRefreshValuesFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-stack-test-*")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	var (
+		valuesFile   = filepath.Join(dir, "values.yaml")
+		snapshotFile = filepath.Join(dir, UpstreamValuesDir, "values.yaml")
+	)
+
+	assert.NoError(t, ioutil.WriteFile(valuesFile, []byte("foo: 1\n"), 0644))
+
+	added, err := refreshValuesFile(valuesFile, snapshotFile, "upstream.yaml", []byte("foo: 0\nbar: 0\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"bar"}}, added)
+
+	// user removed bar
+	assert.NoError(t, ioutil.WriteFile(valuesFile, []byte("foo: 1\n"), 0644))
+
+	added, err = refreshValuesFile(valuesFile, snapshotFile, "upstream.yaml", []byte("foo: 0\nbar: 0\n"))
+	assert.NoError(t, err)
+	assert.Len(t, added, 0)
+
+	data, err := ioutil.ReadFile(valuesFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo: 1\n", string(data))
+
+	data, err = ioutil.ReadFile(snapshotFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo: 0\nbar: 0\n", string(data))
+}
+
+func TestEnsureRefreshValuesMissingUpstream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-stack-test-*")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	var (
+		localChartsDir = filepath.Join(dir, "local-charts")
+		envDir         = filepath.Join(dir, "envs")
+	)
+
+	// chart without values.yaml
+	chartDir := filepath.Join(localChartsDir, "foo", "latest")
+	if !assert.NoError(t, os.MkdirAll(chartDir, 0755)) {
+		return
+	}
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(chartDir, "Chart.yaml"), []byte("name: foo\n"), 0644))
+
+	charts := map[string]*ChartSpec{
+		"foo@latest": {
+			Name:        "foo@latest",
+			ChartSource: &ChartSource{Local: &ChartFromLocalPath{}},
+		},
+	}
+	e := Environment{
+		Name:        "test",
+		Deployments: []DeploymentSpec{{Name: "default/foo", Chart: "foo@latest"}},
+	}
+
+	valuesFile := filepath.Join(e.ValuesDir(envDir), e.Deployments[0].Filename(""))
+	if !assert.NoError(t, os.MkdirAll(filepath.Dir(valuesFile), 0755)) {
+		return
+	}
+	assert.NoError(t, ioutil.WriteFile(valuesFile, []byte("foo: bar\n"), 0644))
+
+	assert.NoError(t, e.Ensure(context.TODO(), true, filepath.Join(dir, "charts"), localChartsDir, envDir, charts))
+
+	data, err := ioutil.ReadFile(valuesFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo: bar\n", string(data))
+}
@@ -0,0 +1,97 @@
+package conf
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+	"sigs.k8s.io/yaml"
+)
+
+// schemaError is a violation of values schema at key path
+type schemaError struct {
+	Path    []string
+	Message string
+}
+
+func (e schemaError) Error() string {
+	if len(e.Path) == 0 {
+		return e.Message
+	}
+
+	return fmt.Sprintf("%s: %s", formatValuesPath(e.Path), e.Message)
+}
+
+// validateValuesSchema validates values against `values.schema.json` in the same way as helm
+// (chartutil.ValidateAgainstSingleSchema), violations are reported with key paths in values
+func validateValuesSchema(schemaJSON []byte, values map[string]interface{}) ([]schemaError, error) {
+	valuesData, err := yaml.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+
+	valuesJSON, err := yaml.YAMLToJSON(valuesData)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(valuesJSON, []byte("null")) {
+		valuesJSON = []byte("{}")
+	}
+
+	result, err := gojsonschema.Validate(
+		gojsonschema.NewBytesLoader(schemaJSON),
+		gojsonschema.NewBytesLoader(valuesJSON),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []schemaError
+	for _, e := range result.Errors() {
+		ret = append(ret, schemaError{
+			Path:    schemaErrorPath(values, e.Context()),
+			Message: e.Description(),
+		})
+	}
+
+	return ret, nil
+}
+
+// schemaErrorPath converts json context of schema error to key path in values, list index
+// is represented as `[i]`
+func schemaErrorPath(values map[string]interface{}, ctx *gojsonschema.JsonContext) []string {
+	if ctx == nil {
+		return nil
+	}
+
+	// use a delimiter not in keys, keys can contain dots
+	const delimiter = "\x00"
+	parts := strings.Split(ctx.String(delimiter), delimiter)
+
+	var (
+		ret     []string
+		current interface{} = values
+	)
+	for _, p := range parts[1:] {
+		switch t := current.(type) {
+		case []interface{}:
+			idx, err := strconv.Atoi(p)
+			if err == nil && idx >= 0 && idx < len(t) {
+				ret = append(ret, fmt.Sprintf("[%d]", idx))
+				current = t[idx]
+				continue
+			}
+		case map[string]interface{}:
+			current = t[p]
+		default:
+			current = nil
+		}
+
+		ret = append(ret, p)
+	}
+
+	return ret
+}
@@ -0,0 +1,175 @@
+package conf
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/multierr"
+	"sigs.k8s.io/yaml"
+)
+
+func TestValidateValuesSchema(t *testing.T) {
+	const schemaJSON = `{
+  "type": "object",
+  "required": ["image"],
+  "properties": {
+    "image": {
+      "type": "object",
+      "properties": {
+        "pullPolicy": {"enum": ["Always", "IfNotPresent", "Never"]},
+        "tag": {"type": "string"}
+      }
+    },
+    "replicaCount": {"type": "integer", "minimum": 1},
+    "ports": {"type": "array", "items": {"$ref": "#/definitions/port"}},
+    "annotations": {"type": "object", "additionalProperties": {"type": "string"}},
+    "email": {"type": "string", "format": "email"}
+  },
+  "definitions": {
+    "port": {"type": "integer", "maximum": 65535}
+  }
+}`
+
+	tests := []struct {
+		name   string
+		values string
+		errors []string
+	}{
+		{
+			name:   "Valid",
+			values: `{image: {pullPolicy: Always, tag: "1.0"}, replicaCount: 2, ports: [80, 443]}`,
+		},
+		{
+			name:   "Required",
+			values: `{replicaCount: 1}`,
+			errors: []string{"image is required"},
+		},
+		{
+			name:   "Type",
+			values: `{image: {tag: 1.0}, replicaCount: 1.5}`,
+			errors: []string{
+				"image.tag: Invalid type. Expected: string, given: integer",
+				"replicaCount: Invalid type. Expected: integer, given: number",
+			},
+		},
+		{
+			name:   "Enum And Ref",
+			values: `{image: {pullPolicy: always}, ports: [80, 70000]}`,
+			errors: []string{
+				`image.pullPolicy: image.pullPolicy must be one of the following: "Always", "IfNotPresent", "Never"`,
+				"ports[1]: Must be less than or equal to 65535",
+			},
+		},
+		{
+			name:   "Dotted Key And Format",
+			values: `{image: {}, annotations: {example.com/foo: 1}, email: foo}`,
+			errors: []string{
+				"annotations.example.com/foo: Invalid type. Expected: string, given: integer",
+				"email: Does not match format 'email'",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values := make(map[string]interface{})
+			if !assert.NoError(t, yaml.Unmarshal([]byte(test.values), &values)) {
+				return
+			}
+
+			errs, err := validateValuesSchema([]byte(schemaJSON), values)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			var actual []string
+			for _, e := range errs {
+				actual = append(actual, e.Error())
+			}
+
+			assert.ElementsMatch(t, test.errors, actual)
+		})
+	}
+}
+
+func TestEnvironment_validateValues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-stack-test-*")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	var (
+		chartsDir      = filepath.Join(dir, "charts")
+		localChartsDir = filepath.Join(dir, "local-charts")
+		envDir         = filepath.Join(dir, "envs")
+	)
+
+	chart := &ChartSpec{
+		Name:        "foo@latest",
+		ChartSource: &ChartSource{Local: &ChartFromLocalPath{}},
+	}
+	d := DeploymentSpec{Name: "default/foo", Chart: "foo@latest"}
+	e := Environment{Name: "test", Deployments: []DeploymentSpec{d}}
+
+	valuesFile := filepath.Join("envs", "test", d.Filename(""))
+	if !writeTestFiles(t, dir, map[string]string{
+		"local-charts/foo/latest/Chart.yaml":  "name: foo\n",
+		"local-charts/foo/latest/values.yaml": "port: 80\n",
+		"local-charts/foo/latest/values.schema.json": `{
+  "properties": {
+    "port": {"type": "integer"},
+    "password": {"type": "string", "minLength": 8}
+  }
+}`,
+		valuesFile: "port: http\npassword: ref+env://HELM_STACK_TEST_PASSWORD\n",
+	}) {
+		return
+	}
+
+	dv, err := e.resolveValues(chartsDir, localChartsDir, envDir, d, chart)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// refs not resolved
+	err = e.validateValues(chartsDir, localChartsDir, d, chart, dv, dv.Values, true)
+	assert.Len(t, multierr.Errors(err), 1)
+	assert.Contains(t, err.Error(), filepath.Join(dir, valuesFile)+": port: Invalid type")
+
+	// validated after refs resolved
+	r := NewValuesRefResolver(false)
+	r.Register("env", RefResolverFunc(func(_ context.Context, _ string) (string, error) {
+		return "short", nil
+	}))
+
+	values, err := r.ResolveValues(context.TODO(), "", dv.Values)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	err = e.validateValues(chartsDir, localChartsDir, d, chart, dv, values, false)
+	assert.Len(t, multierr.Errors(err), 2)
+	assert.Contains(t, err.Error(), filepath.Join(dir, valuesFile)+": password: String length must be")
+}
+
+func TestDeploymentValues_SourceOf(t *testing.T) {
+	dv := &deploymentValues{
+		sources: []valuesSource{
+			{file: "parent.yaml", values: map[string]interface{}{
+				"foo": map[string]interface{}{"bar": "a"},
+			}},
+			{file: "sub.yaml", prefix: []string{"foo"}, values: map[string]interface{}{
+				"baz": []interface{}{"b"},
+			}},
+		},
+	}
+
+	assert.Equal(t, "parent.yaml", dv.sourceOf([]string{"foo", "bar"}))
+	assert.Equal(t, "sub.yaml", dv.sourceOf([]string{"foo", "baz", "[0]"}))
+	assert.Equal(t, "chart values", dv.sourceOf([]string{"foo", "baz", "[1]"}))
+}
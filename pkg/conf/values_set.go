@@ -0,0 +1,170 @@
+package conf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/strvals"
+)
+
+// setValueOp is a single `set`, `setString` or `setFile` entry with its value resolved
+type setValueOp struct {
+	kind  string
+	key   string
+	value interface{}
+}
+
+// resolveSetValues resolves `set`, `setString` and `setFile` of the deployment in the
+// same order as helm applies `--set`, `--set-string` and `--set-file`, files are read here
+// only once
+func (e Environment) resolveSetValues(envDir string, d DeploymentSpec) ([]setValueOp, error) {
+	var ops []setValueOp
+	for _, key := range sortedKeys(d.Set) {
+		ops = append(ops, setValueOp{kind: "set", key: key, value: d.Set[key]})
+	}
+
+	for _, key := range sortedKeys(d.SetString) {
+		var v string
+		switch t := d.SetString[key].(type) {
+		case nil:
+		case float64:
+			v = strconv.FormatFloat(t, 'f', -1, 64)
+		default:
+			v = toString(t)
+		}
+
+		ops = append(ops, setValueOp{kind: "setString", key: key, value: v})
+	}
+
+	keys := make([]string, 0, len(d.SetFile))
+	for k := range d.SetFile {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		file := d.SetFile[key]
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(e.ValuesDir(envDir), file)
+		}
+
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read setFile %q: %w", file, err)
+		}
+
+		ops = append(ops, setValueOp{kind: "setFile", key: key, value: string(data)})
+	}
+
+	return ops, nil
+}
+
+// applySetValues applies resolved set values to a copy of values
+func applySetValues(values map[string]interface{}, ops []setValueOp) (map[string]interface{}, error) {
+	// do not modify values shared with values sources
+	values = copyValues(values).(map[string]interface{})
+
+	for _, op := range ops {
+		var err error
+		switch op.kind {
+		case "set":
+			var v string
+			v, err = formatSetValue(op.value)
+			if err == nil {
+				err = strvals.ParseInto(op.key+"="+v, values)
+			}
+		case "setString":
+			err = strvals.ParseIntoString(op.key+"="+escapeSetValue(op.value.(string)), values)
+		case "setFile":
+			// file content is read when resolving set values, the value is a placeholder
+			err = strvals.ParseIntoFile(op.key+"=-", values, func([]rune) (interface{}, error) {
+				return op.value, nil
+			})
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", op.kind, op.key, err)
+		}
+	}
+
+	return values, nil
+}
+
+// formatSetValue formats value of `set` as the value of `helm --set`, strings are used as is
+// (e.g. `false` is a bool, `{a,b}` is a list), lists are formatted as `{a,b}`
+func formatSetValue(v interface{}) (string, error) {
+	switch t := v.(type) {
+	case string:
+		return t, nil
+	case []interface{}:
+		items := make([]string, len(t))
+		for i, item := range t {
+			switch item.(type) {
+			case map[string]interface{}, []interface{}:
+				return "", fmt.Errorf("nested list item is not supported, use keys like `a[0].b`")
+			}
+
+			s, err := formatSetValue(item)
+			if err != nil {
+				return "", err
+			}
+
+			items[i] = strings.NewReplacer(`\`, `\\`, ",", `\,`, "}", `\}`).Replace(s)
+		}
+
+		return "{" + strings.Join(items, ",") + "}", nil
+	case map[string]interface{}:
+		return "", fmt.Errorf("map value is not supported, use keys like `a.b`")
+	case nil:
+		return "null", nil
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), nil
+	default:
+		return toString(t), nil
+	}
+}
+
+// escapeSetValue escapes characters with special meaning in `helm --set-string` values
+func escapeSetValue(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, ",", `\,`).Replace(s)
+	if strings.HasPrefix(s, "{") {
+		// not a list
+		s = `\` + s
+	}
+
+	return s
+}
+
+// copyValues deep copies maps and lists in values
+func copyValues(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			ret[k] = copyValues(v)
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(t))
+		for i := range t {
+			ret[i] = copyValues(t[i])
+		}
+		return ret
+	default:
+		return v
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,199 @@
+package conf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestApplySetValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   map[string]interface{}
+		op       setValueOp
+		expected map[string]interface{}
+		err      bool
+	}{
+		{
+			name:     "Nested Map",
+			values:   map[string]interface{}{"image": map[string]interface{}{"repo": "nginx"}},
+			op:       setValueOp{kind: "set", key: "image.tag", value: "v1.19"},
+			expected: map[string]interface{}{"image": map[string]interface{}{"repo": "nginx", "tag": "v1.19"}},
+		},
+		{
+			name:     "Escaped Dot",
+			values:   map[string]interface{}{},
+			op:       setValueOp{kind: "set", key: `annotations.example\.com/foo`, value: "bar"},
+			expected: map[string]interface{}{"annotations": map[string]interface{}{"example.com/foo": "bar"}},
+		},
+		{
+			name:   "List Index",
+			values: map[string]interface{}{"args": []interface{}{"a"}},
+			op:     setValueOp{kind: "set", key: "args[2]", value: "c"},
+			expected: map[string]interface{}{
+				"args": []interface{}{"a", nil, "c"},
+			},
+		},
+		{
+			name:   "Map In List",
+			values: map[string]interface{}{},
+			op:     setValueOp{kind: "set", key: "env[0].name", value: "FOO"},
+			expected: map[string]interface{}{
+				"env": []interface{}{map[string]interface{}{"name": "FOO"}},
+			},
+		},
+		{
+			name:     "Typed String",
+			values:   map[string]interface{}{"redis": map[string]interface{}{"enabled": true}},
+			op:       setValueOp{kind: "set", key: "redis.enabled", value: "false"},
+			expected: map[string]interface{}{"redis": map[string]interface{}{"enabled": false}},
+		},
+		{
+			name:     "Number",
+			values:   map[string]interface{}{},
+			op:       setValueOp{kind: "set", key: "replicas", value: float64(2)},
+			expected: map[string]interface{}{"replicas": int64(2)},
+		},
+		{
+			name:     "List",
+			values:   map[string]interface{}{},
+			op:       setValueOp{kind: "set", key: "args", value: []interface{}{"a,b", float64(1), true}},
+			expected: map[string]interface{}{"args": []interface{}{"a,b", int64(1), true}},
+		},
+		{
+			name:     "String",
+			values:   map[string]interface{}{},
+			op:       setValueOp{kind: "setString", key: "a", value: `{1.0,true}\`},
+			expected: map[string]interface{}{"a": `{1.0,true}\`},
+		},
+		{
+			name:     "File",
+			values:   map[string]interface{}{},
+			op:       setValueOp{kind: "setFile", key: "tls.cert", value: "a,b\n"},
+			expected: map[string]interface{}{"tls": map[string]interface{}{"cert": "a,b\n"}},
+		},
+		{
+			name:   "Map Value",
+			values: map[string]interface{}{},
+			op:     setValueOp{kind: "set", key: "a", value: map[string]interface{}{"b": "c"}},
+			err:    true,
+		},
+		{
+			name:   "Not A List",
+			values: map[string]interface{}{"args": map[string]interface{}{}},
+			op:     setValueOp{kind: "set", key: "args[0]", value: "a"},
+			err:    true,
+		},
+		{
+			name:   "No Value",
+			values: map[string]interface{}{},
+			op:     setValueOp{kind: "set", key: "a", value: "b,c"},
+			err:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := applySetValues(test.values, []setValueOp{test.op})
+			if test.err {
+				if err == nil {
+					t.Errorf("expecting error, got values %v", actual)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("expecting %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestEnvironment_resolveSetValues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-stack-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	e := Environment{Name: "test"}
+	if err = os.MkdirAll(e.ValuesDir(dir), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(e.ValuesDir(dir), "tls.crt")
+	if err = ioutil.WriteFile(certFile, []byte("cert"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := DeploymentSpec{
+		Name:      "default/foo",
+		Set:       map[string]interface{}{"tls.cert": "set", "replicas": float64(2)},
+		SetString: map[string]interface{}{"tag": float64(1.1)},
+		SetFile:   map[string]string{"tls.cert": "tls.crt"},
+	}
+
+	ops, err := e.resolveSetValues(dir, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedOps := []setValueOp{
+		{kind: "set", key: "replicas", value: float64(2)},
+		{kind: "set", key: "tls.cert", value: "set"},
+		{kind: "setString", key: "tag", value: "1.1"},
+		{kind: "setFile", key: "tls.cert", value: "cert"},
+	}
+	if !reflect.DeepEqual(ops, expectedOps) {
+		t.Errorf("expecting %v, got %v", expectedOps, ops)
+	}
+
+	// files are not read again when applied
+	if err = os.Remove(certFile); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		values   map[string]interface{}
+		expected map[string]interface{}
+	}{
+		{
+			values: map[string]interface{}{},
+			expected: map[string]interface{}{
+				"replicas": int64(2), "tag": "1.1", "tls": map[string]interface{}{"cert": "cert"},
+			},
+		},
+		{
+			values: map[string]interface{}{"tls": map[string]interface{}{"key": "key"}},
+			expected: map[string]interface{}{
+				"replicas": int64(2), "tag": "1.1", "tls": map[string]interface{}{"cert": "cert", "key": "key"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		actual, err := applySetValues(test.values, ops)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("expecting %v, got %v", test.expected, actual)
+		}
+	}
+
+	if !reflect.DeepEqual(tests[1].values, map[string]interface{}{"tls": map[string]interface{}{"key": "key"}}) {
+		t.Errorf("original values modified: %v", tests[1].values)
+	}
+
+	_, err = e.resolveSetValues(dir, d)
+	if err == nil {
+		t.Errorf("expecting error for missing setFile")
+	}
+}
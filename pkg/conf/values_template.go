@@ -0,0 +1,164 @@
+package conf
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+	"sigs.k8s.io/yaml"
+)
+
+// ValuesTemplateContext is the data available to values templates (values files with
+// `.tmpl` suffix)
+type ValuesTemplateContext struct {
+	Environment ValuesTemplateEnvironment
+	Deployment  ValuesTemplateDeployment
+	Chart       ValuesTemplateChart
+}
+
+type ValuesTemplateEnvironment struct {
+	Name        string
+	KubeContext string
+	Labels      map[string]string
+}
+
+type ValuesTemplateDeployment struct {
+	Namespace   string
+	Name        string
+	ReleaseName string
+}
+
+type ValuesTemplateChart struct {
+	// Repo name of the chart, empty if not from a helm repo
+	Repo    string
+	Name    string
+	Version string
+
+	// SubChart name if rendering values for a sub chart
+	SubChart string
+}
+
+func newValuesTemplateContext(e Environment, d DeploymentSpec, subChartName string) *ValuesTemplateContext {
+	namespace, name := d.NamespaceAndName()
+	repoName, chartName, chartVersion := getChartRepoNameChartNameChartVersion(d.Chart)
+
+	labels := make(map[string]string)
+	for k, v := range e.Labels {
+		labels[k] = v
+	}
+
+	return &ValuesTemplateContext{
+		Environment: ValuesTemplateEnvironment{
+			Name:        e.Name,
+			KubeContext: e.KubeContext,
+			Labels:      labels,
+		},
+		Deployment: ValuesTemplateDeployment{
+			Namespace:   namespace,
+			Name:        name,
+			ReleaseName: d.GetReleaseName(),
+		},
+		Chart: ValuesTemplateChart{
+			Repo:     repoName,
+			Name:     chartName,
+			Version:  chartVersion,
+			SubChart: subChartName,
+		},
+	}
+}
+
+func renderValuesTemplate(name string, data []byte, ctx *ValuesTemplateContext) ([]byte, error) {
+	tpl, err := template.New(name).
+		Option("missingkey=zero").
+		Funcs(valuesTemplateFuncs()).
+		Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse values template: %w", err)
+	}
+
+	buf := new(bytes.Buffer)
+	err = tpl.Execute(buf, ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute values template: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// valuesTemplateFuncs are functions available in chart templates: sprig functions except
+// `env` and `expandenv`, and `toYaml`, `fromYaml`, `toJson`, `fromJson` and `required` of
+// helm, conversion functions fail the template instead of returning empty results
+func valuesTemplateFuncs() template.FuncMap {
+	funcs := sprig.TxtFuncMap()
+	delete(funcs, "env")
+	delete(funcs, "expandenv")
+
+	extra := template.FuncMap{
+		"toYaml": func(v interface{}) (string, error) {
+			data, err := yaml.Marshal(v)
+			if err != nil {
+				return "", err
+			}
+			return strings.TrimSuffix(string(data), "\n"), nil
+		},
+		"fromYaml": func(s string) (map[string]interface{}, error) {
+			m := make(map[string]interface{})
+			err := yaml.Unmarshal([]byte(s), &m)
+			return m, err
+		},
+		"fromYamlArray": func(s string) ([]interface{}, error) {
+			var a []interface{}
+			err := yaml.Unmarshal([]byte(s), &a)
+			return a, err
+		},
+		"toJson": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			if err != nil {
+				return "", err
+			}
+			return string(data), nil
+		},
+		"fromJson": func(s string) (map[string]interface{}, error) {
+			m := make(map[string]interface{})
+			err := json.Unmarshal([]byte(s), &m)
+			return m, err
+		},
+		"fromJsonArray": func(s string) ([]interface{}, error) {
+			var a []interface{}
+			err := json.Unmarshal([]byte(s), &a)
+			return a, err
+		},
+		"required": func(msg string, v interface{}) (interface{}, error) {
+			if v == nil {
+				return nil, errors.New(msg)
+			}
+			if s, ok := v.(string); ok && s == "" {
+				return nil, errors.New(msg)
+			}
+			return v, nil
+		},
+	}
+
+	for k, f := range extra {
+		funcs[k] = f
+	}
+
+	return funcs
+}
+
+func toString(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case []byte:
+		return string(t)
+	case fmt.Stringer:
+		return t.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,84 @@
+package conf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderValuesTemplate(t *testing.T) {
+	e := Environment{
+		Name:   "prod",
+		Labels: map[string]string{"region": "eu-west-1"},
+	}
+	d := DeploymentSpec{Name: "storage/redis", Chart: "bitnami/redis@10.0.0"}
+
+	tests := []struct {
+		name     string
+		tpl      string
+		expected string
+		err      bool
+	}{
+		{
+			name:     "Context",
+			tpl:      `host: {{ .Deployment.Name }}.{{ .Deployment.Namespace }}.{{ .Environment.Name }}`,
+			expected: `host: redis.storage.prod`,
+		},
+		{
+			name:     "Chart",
+			tpl:      `chart: {{ .Chart.Repo }}/{{ .Chart.Name }}@{{ .Chart.Version }}`,
+			expected: `chart: bitnami/redis@10.0.0`,
+		},
+		{
+			name:     "Functions",
+			tpl:      `region: {{ .Environment.Labels.region | upper | quote }}`,
+			expected: `region: "EU-WEST-1"`,
+		},
+		{
+			name:     "Default",
+			tpl:      `zone: {{ .Environment.Labels.zone | default "a" }}`,
+			expected: `zone: a`,
+		},
+		{
+			name:     "Sprig",
+			tpl:      `zones: {{ list "a" "b" | join "," }}-{{ .Environment.Name | trunc 1 }}`,
+			expected: `zones: a,b-p`,
+		},
+		{
+			name:     "Helm",
+			tpl:      `{{ (fromYaml "a: [1]").a | toJson }}`,
+			expected: `[1]`,
+		},
+		{
+			name: "Required",
+			tpl:  `zone: {{ required "zone required" .Environment.Labels.zone }}`,
+			err:  true,
+		},
+		{
+			name: "No Env",
+			tpl:  `home: {{ env "HOME" }}`,
+			err:  true,
+		},
+		{
+			name: "Missing Field",
+			tpl:  `foo: {{ .Environment.Foo }}`,
+			err:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ret, err := renderValuesTemplate(test.name, []byte(test.tpl), newValuesTemplateContext(e, d, ""))
+			if test.err {
+				assert.Error(t, err)
+				return
+			}
+
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			assert.Equal(t, test.expected, string(ret))
+		})
+	}
+}
@@ -26,4 +26,11 @@ const (
 
 const (
 	DefaultValuesFile = "values.yaml"
+	ValuesSchemaFile  = "values.schema.json"
+
+	ChartMetadataFile     = "Chart.yaml"
+	ChartRequirementsFile = "requirements.yaml"
+
+	// ValuesTemplateFileExt is the extension appended to values file name to mark it as go template
+	ValuesTemplateFileExt = ".tmpl"
 )